@@ -0,0 +1,362 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command gateway serves the marbles chaincode over HTTP/JSON for local development, no peer needed
+//
+//	POST /marbles                    init_marble
+//	PUT  /marbles/{name}/owner       set_user
+//	GET  /trades                     list_trades
+//	POST /trades/{id}/perform        perform_trade
+//	POST /invoke/{function}          any invoke, the body is the JSON array of args
+//	GET  /query/{function}?arg=...   any query, one arg parameter per arg
+//	GET  /openapi.json               OpenAPI document generated from the function registry
+//
+// The X-Marbles-Caller header names the user signing each transaction. The gateway takes it on trust, it is for
+// development only, a peer reads the caller from the transaction's certificate.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+	"github.com/binhn/marbles-chaincode/txlog"
+)
+
+var addr = flag.String("addr", "localhost:8080", "address to listen on")
+var statePath = flag.String("state", "", "JSON file holding the ledger state, state is only kept in memory without one")
+var presetName = flag.String("preset", "part2", "which functions are switched on: part1, part2 or experimental")
+var recordPath = flag.String("record", "", "append every invoke and query to this transaction log, for cmd/replay")
+
+var callerHeader = "X-Marbles-Caller"
+
+type Gateway struct {
+	mu sync.Mutex												//one transaction at a time, like a peer
+	cc *marbles.Chaincode
+	runInvoke func(stub marbles.Stub, function string, args []string) ([]byte, error)		//the chaincode's, or the recorder's
+	runRead func(stub marbles.Stub, function string, args []string) ([]byte, error)
+	stub *memstub.Stub
+	save bool
+}
+
+type InvokeResponse struct{
+	Result json.RawMessage `json:"result"`
+	Events []marbles.Event `json:"events"`
+}
+
+type ErrorResponse struct{
+	Error string `json:"error"`
+}
+
+// httpError is an error with the status code to answer it with
+type httpError struct{
+	status int
+	msg string
+}
+
+func (e httpError) Error() string {
+	return e.msg
+}
+
+func main() {
+	flag.Parse()
+	preset, ok := marbles.FindPreset(*presetName)
+	if !ok {
+		log.Fatal("Unknown preset " + *presetName)
+	}
+	cc := marbles.NewChaincode(preset)
+	g := &Gateway{cc: cc, runInvoke: cc.Invoke, runRead: cc.Read, stub: memstub.New()}
+	if *statePath != "" {
+		stub, err := memstub.Open(*statePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.stub = stub
+		g.save = true
+	}
+	if *recordPath != "" {
+		recorder, logFile, err := txlog.OpenRecorder(cc, *recordPath, g.stub)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+		g.runInvoke, g.runRead = recorder.Invoke, recorder.Read
+	}
+
+	log.Printf("serving %s marbles on http://%s", preset.Name, *addr)
+	log.Fatal(http.ListenAndServe(*addr, g))
+}
+
+// ============================================================================================================================
+// Serve HTTP - route a request to its chaincode function
+// ============================================================================================================================
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var err error
+
+	switch {
+	case len(parts) == 1 && parts[0] == "openapi.json":
+		err = allow(r, "GET")
+		if err == nil {
+			writeJSON(w, http.StatusOK, openAPI(g.cc))
+		}
+	case len(parts) == 1 && parts[0] == "marbles":
+		err = allow(r, "POST")
+		if err == nil {
+			err = g.createMarble(w, r)
+		}
+	case len(parts) == 3 && parts[0] == "marbles" && parts[2] == "owner":
+		err = allow(r, "PUT")
+		if err == nil {
+			err = g.setOwner(w, r, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "trades":
+		err = allow(r, "GET")
+		if err == nil {
+			err = g.listTrades(w, r)
+		}
+	case len(parts) == 3 && parts[0] == "trades" && parts[2] == "perform":
+		err = allow(r, "POST")
+		if err == nil {
+			err = g.performTrade(w, r, parts[1])
+		}
+	case len(parts) == 2 && parts[0] == "invoke":
+		err = allow(r, "POST")
+		if err == nil {
+			var args []string
+			err = readBody(r, &args)
+			if err == nil {
+				err = g.invoke(w, r, parts[1], args)
+			}
+		}
+	case len(parts) == 2 && parts[0] == "query":
+		err = allow(r, "GET")
+		if err == nil {
+			err = g.query(w, r, parts[1], r.URL.Query()["arg"])
+		}
+	default:
+		err = httpError{http.StatusNotFound, "No route for " + r.URL.Path}
+	}
+
+	if err != nil {
+		status := http.StatusBadRequest										//the chaincode turned it down
+		if herr, ok := err.(httpError); ok {
+			status = herr.status
+		}
+		writeJSON(w, status, ErrorResponse{Error: err.Error()})
+	}
+}
+
+// ============================================================================================================================
+// Create Marble - POST /marbles {"name": "m1", "color": "blue", "size": 16, "user": "bob", "minter": "", "attributes": {}}
+// ============================================================================================================================
+func (g *Gateway) createMarble(w http.ResponseWriter, r *http.Request) error {
+	var body struct{
+		Name string `json:"name"`
+		Color string `json:"color"`
+		Size int `json:"size"`
+		User string `json:"user"`
+		Minter string `json:"minter"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	err := readBody(r, &body)
+	if err != nil {
+		return err
+	}
+
+	args := []string{body.Name, body.Color, strconv.Itoa(body.Size), body.User}
+	if body.Minter != "" || body.Attributes != nil {
+		args = append(args, body.Minter)
+	}
+	if body.Attributes != nil {
+		attributesAsBytes, _ := json.Marshal(body.Attributes)
+		args = append(args, string(attributesAsBytes))
+	}
+	return g.invoke(w, r, "init_marble", args)
+}
+
+// ============================================================================================================================
+// Set Owner - PUT /marbles/{name}/owner {"user": "alice"}
+// ============================================================================================================================
+func (g *Gateway) setOwner(w http.ResponseWriter, r *http.Request, name string) error {
+	var body struct{
+		User string `json:"user"`
+	}
+	err := readBody(r, &body)
+	if err != nil {
+		return err
+	}
+	return g.invoke(w, r, "set_user", []string{name, body.User})
+}
+
+// ============================================================================================================================
+// List Trades - GET /trades?page_size=10&bookmark=&sort=timestamp&order=asc&user=bob&color=red&size=16
+// ============================================================================================================================
+func (g *Gateway) listTrades(w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	var params marbles.TradeListParams
+	params.Bookmark = values.Get("bookmark")
+	params.Sort = values.Get("sort")
+	params.Order = values.Get("order")
+	params.Filter.User = values.Get("user")
+	params.Filter.Color = values.Get("color")
+
+	var err error
+	if values.Get("page_size") != "" {
+		params.PageSize, err = strconv.Atoi(values.Get("page_size"))
+		if err != nil {
+			return errors.New("page_size must be a number")
+		}
+	}
+	if values.Get("size") != "" {
+		params.Filter.Size, err = strconv.Atoi(values.Get("size"))
+		if err != nil {
+			return errors.New("size must be a number")
+		}
+	}
+
+	paramsAsBytes, _ := json.Marshal(params)
+	return g.query(w, r, "list_trades", []string{string(paramsAsBytes)})
+}
+
+// ============================================================================================================================
+// Perform Trade - POST /trades/{id}/perform {"closer_marble": "m2", "willing_index": 0}
+// ============================================================================================================================
+func (g *Gateway) performTrade(w http.ResponseWriter, r *http.Request, id string) error {
+	var body struct{
+		CloserMarble string `json:"closer_marble"`
+		WillingIndex *int `json:"willing_index"`							//nil when left out, not option 0
+	}
+	err := readBody(r, &body)
+	if err != nil {
+		return err
+	}
+	if body.WillingIndex == nil {
+		return errors.New("willing_index is required")
+	}
+	return g.invoke(w, r, "perform_trade", []string{id, body.CloserMarble, strconv.Itoa(*body.WillingIndex)})
+}
+
+// ============================================================================================================================
+// Invoke - run an invoke as the request's caller, keep its writes and answer with its result and events
+// ============================================================================================================================
+func (g *Gateway) invoke(w http.ResponseWriter, r *http.Request, function string, args []string) error {
+	fn, ok := g.cc.Lookup(function)
+	if !ok || fn.Kind != "invoke" {
+		return httpError{http.StatusNotFound, "No invoke function " + function}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	before := g.stub.Snapshot()
+	g.stub.ClearEvents()
+	g.stub.Caller = r.Header.Get(callerHeader)
+	g.stub.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)		//stamped like a peer stamps a transaction
+	res, err := g.runInvoke(g.stub, function, args)
+	if err != nil {
+		g.stub.Restore(before)												//a failed transaction leaves no writes behind
+		return err
+	}
+	if g.save {
+		err = g.stub.Save()
+		if err != nil {
+			return httpError{http.StatusInternalServerError, err.Error()}
+		}
+	}
+
+	events := g.stub.Events
+	if events == nil {
+		events = []marbles.Event{}
+	}
+	writeJSON(w, http.StatusOK, InvokeResponse{Result: rawResult(res), Events: events})
+	return nil
+}
+
+// ============================================================================================================================
+// Query - run a query as the request's caller and answer with what it read
+// ============================================================================================================================
+func (g *Gateway) query(w http.ResponseWriter, r *http.Request, function string, args []string) error {
+	fn, ok := g.cc.Lookup(function)
+	if !ok || fn.Kind != "query" {
+		return httpError{http.StatusNotFound, "No query function " + function}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stub.Caller = r.Header.Get(callerHeader)
+	g.stub.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)		//stamped like a peer stamps a transaction
+	res, err := g.runRead(g.stub, function, args)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rawResult(res))
+	return nil
+}
+
+// ============================================================================================================================
+// Raw Result - a function's result as JSON, results that are not JSON become a string
+// ============================================================================================================================
+func rawResult(res []byte) json.RawMessage {
+	if len(res) == 0 {
+		return json.RawMessage("null")
+	}
+	if json.Valid(res) {
+		return json.RawMessage(res)
+	}
+	jsonAsBytes, _ := json.Marshal(string(res))
+	return json.RawMessage(jsonAsBytes)
+}
+
+func allow(r *http.Request, method string) error {
+	if r.Method != method {
+		return httpError{http.StatusMethodNotAllowed, "Expecting " + method + " for " + r.URL.Path}
+	}
+	return nil
+}
+
+func readBody(r *http.Request, v interface{}) error {
+	bodyAsBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(bodyAsBytes, v)
+	if err != nil {
+		return fmt.Errorf("Body is not the JSON expected: %s", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonAsBytes, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonAsBytes)
+}
@@ -0,0 +1,179 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+)
+
+type object map[string]interface{}
+
+// ============================================================================================================================
+// OpenAPI - describe the gateway's routes, one per switched on function plus the REST ones, operationIds are the
+// function's name prefixed with the route kind so the REST and generic routes never share one
+// ============================================================================================================================
+func openAPI(cc *marbles.Chaincode) object {
+	paths := object{
+		"/marbles": object{
+			"post": operation("rest_init_marble", "create a new marble", body(object{
+				"name": str(), "color": str(), "size": integer(), "user": str(), "minter": str(),
+				"attributes": object{"type": "object", "additionalProperties": str()},
+			}, "name", "color", "size", "user"), nil),
+		},
+		"/marbles/{name}/owner": object{
+			"put": operation("rest_set_user", "change owner of a marble", body(object{
+				"user": str(),
+			}, "user"), []object{pathParam("name")}),
+		},
+		"/trades": object{
+			"get": operation("rest_list_trades", "read a page of open trades, filtered and sorted", nil, []object{
+				queryParam("page_size", integer()), queryParam("bookmark", str()),
+				queryParam("sort", enum("size", "color", "owner", "timestamp")), queryParam("order", enum("asc", "desc")),
+				queryParam("user", str()), queryParam("color", str()), queryParam("size", integer()),
+			}),
+		},
+		"/trades/{id}/perform": object{
+			"post": operation("rest_perform_trade", "forfill an open trade order", body(object{
+				"closer_marble": str(), "willing_index": integer(),
+			}, "closer_marble", "willing_index"), []object{pathParam("id")}),
+		},
+	}
+
+	for path, name := range map[string]string{"/marbles": "init_marble", "/marbles/{name}/owner": "set_user", "/trades": "list_trades", "/trades/{id}/perform": "perform_trade"}{
+		if _, ok := cc.Lookup(name); !ok {
+			delete(paths, path)												//the preset has it switched off
+		}
+	}
+
+	for _, fn := range cc.Functions(){
+		min, max := argCounts(fn.Args)
+		args := object{"type": "array", "items": str(), "minItems": min}
+		if max >= 0 {
+			args["maxItems"] = max
+		}
+		doc := fn.Doc + ". Args: " + strings.Join(fn.Args, ", ") + ", *starred* args are optional, args ending in ... repeat"
+		if len(fn.Args) == 0 {
+			doc = fn.Doc + ". Takes no args"
+		}
+
+		if fn.Kind == "invoke" {
+			paths["/invoke/" + fn.Name] = object{
+				"post": operation("invoke_" + fn.Name, doc, object{
+					"required": true,
+					"content": object{"application/json": object{"schema": args}},
+				}, nil),
+			}
+		} else {
+			paths["/query/" + fn.Name] = object{
+				"get": operation("query_" + fn.Name, doc, nil, []object{
+					{"name": "arg", "in": "query", "description": "one per arg, in order", "schema": args, "style": "form", "explode": true},
+				}),
+			}
+		}
+	}
+
+	return object{
+		"openapi": "3.0.0",
+		"info": object{"title": "marbles chaincode gateway", "version": "1"},
+		"paths": paths,
+		"components": object{
+			"schemas": object{
+				"InvokeResponse": object{"type": "object", "properties": object{
+					"result": object{"description": "what the function returned, strings that are not JSON are quoted"},
+					"events": object{"type": "array", "items": object{"type": "object", "properties": object{
+						"name": str(), "payload": object{},
+					}}},
+				}},
+				"ErrorResponse": object{"type": "object", "properties": object{"error": str()}},
+			},
+		},
+	}
+}
+
+// ============================================================================================================================
+// Arg Counts - how many args a function takes, max is -1 when the last arg repeats
+// ============================================================================================================================
+func argCounts(args []string) (int, int) {
+	min := 0
+	max := len(args)
+	for _, arg := range args{
+		if !strings.HasPrefix(arg, "*") {
+			min++
+		}
+		if strings.HasSuffix(strings.Trim(arg, "*"), "...") {
+			max = -1
+		}
+	}
+	return min, max
+}
+
+func operation(id string, doc string, requestBody object, params []object) object {
+	op := object{
+		"operationId": id,
+		"summary": doc,
+		"responses": object{
+			"200": object{"description": "done", "content": object{"application/json": object{}}},
+			"400": errorResponse("the chaincode turned it down"),
+			"404": errorResponse("no such function"),
+		},
+	}
+	if requestBody != nil {
+		op["requestBody"] = requestBody
+	}
+	op["parameters"] = append([]object{
+		{"name": callerHeader, "in": "header", "description": "user signing the transaction", "schema": str()},
+	}, params...)
+	return op
+}
+
+func body(properties object, required ...string) object {
+	return object{
+		"required": true,
+		"content": object{"application/json": object{"schema": object{
+			"type": "object", "properties": properties, "required": required,
+		}}},
+	}
+}
+
+func errorResponse(doc string) object {
+	return object{"description": doc, "content": object{"application/json": object{"schema": object{"$ref": "#/components/schemas/ErrorResponse"}}}}
+}
+
+func pathParam(name string) object {
+	return object{"name": name, "in": "path", "required": true, "schema": str()}
+}
+
+func queryParam(name string, schema object) object {
+	return object{"name": name, "in": "query", "schema": schema}
+}
+
+func str() object {
+	return object{"type": "string"}
+}
+
+func integer() object {
+	return object{"type": "integer"}
+}
+
+func enum(values ...string) object {
+	return object{"type": "string", "enum": values}
+}
@@ -0,0 +1,179 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command loadgen runs a marble and trade workload against an in memory ledger and reports, per function, latency,
+// GetState/PutState/DelState counts and bytes written. Runs are seeded, so two builds can be compared on the same work.
+//
+//	loadgen -users 20 -marbles 500 -ops 5000 -open 0.3 -close 0.3 -transfer 0.2 -query 0.2
+//	loadgen -workload trades -json > before.json
+package main
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+)
+
+type Workload struct{
+	Users int `json:"users"`
+	Marbles int `json:"marbles"`
+	Ops int `json:"ops"`
+	Open float64 `json:"open"`					//share of ops that open a trade
+	Close float64 `json:"close"`				//share that perform an open trade
+	Transfer float64 `json:"transfer"`			//share that set_user a marble
+	Query float64 `json:"query"`				//share that list marbles, list trades or read stats
+}
+
+var workloads = map[string]Workload{
+	"mixed": {Users: 20, Marbles: 500, Ops: 5000, Open: 0.3, Close: 0.3, Transfer: 0.2, Query: 0.2},
+	"trades": {Users: 50, Marbles: 2000, Ops: 5000, Open: 0.5, Close: 0.5},
+	"transfers": {Users: 50, Marbles: 2000, Ops: 5000, Open: 0.1, Transfer: 0.9},
+	"queries": {Users: 20, Marbles: 1000, Ops: 2000, Open: 0.2, Query: 0.8},
+}
+
+var workloadName = flag.String("workload", "mixed", "named workload to start from: mixed, trades, transfers or queries")
+var presetName = flag.String("preset", "part2", "which functions are switched on: part2 or experimental")
+var seed = flag.Int64("seed", 1, "seed for the workload, the same seed does the same work")
+var users = flag.Int("users", 0, "users, overrides the workload")
+var marbleCount = flag.Int("marbles", 0, "marbles created before the ops, overrides the workload")
+var ops = flag.Int("ops", 0, "ops to run after setup, overrides the workload")
+var openRate = flag.Float64("open", -1, "share of ops that open a trade, overrides the workload")
+var closeRate = flag.Float64("close", -1, "share of ops that perform an open trade, overrides the workload")
+var transferRate = flag.Float64("transfer", -1, "share of ops that transfer a marble, overrides the workload")
+var queryRate = flag.Float64("query", -1, "share of ops that are queries, overrides the workload")
+var jsonOut = flag.Bool("json", false, "print the report as JSON")
+
+var colors = []string{"red", "blue", "green", "white", "black"}
+var sizes = []int{5, 16, 35}
+
+func main() {
+	flag.Parse()
+	workload, ok := workloads[*workloadName]
+	if !ok {
+		fail(errors.New("Unknown workload " + *workloadName))
+	}
+	override(&workload.Users, *users)
+	override(&workload.Marbles, *marbleCount)
+	override(&workload.Ops, *ops)
+	overrideRate(&workload.Open, *openRate)
+	overrideRate(&workload.Close, *closeRate)
+	overrideRate(&workload.Transfer, *transferRate)
+	overrideRate(&workload.Query, *queryRate)
+	if workload.Users < 2 || workload.Marbles < 1 || workload.Open + workload.Close + workload.Transfer + workload.Query <= 0 {
+		fail(errors.New("Workload needs 2 users, a marble and some ops"))
+	}
+	preset, ok := marbles.FindPreset(*presetName)
+	if !ok {
+		fail(errors.New("Unknown preset " + *presetName))
+	}
+
+	stdout := os.Stdout
+	if devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devnull														//the chaincode logs a lot, it still pays for it
+	}
+	report, err := run(marbles.NewChaincode(preset), workload, rand.New(rand.NewSource(*seed)))
+	os.Stdout = stdout
+	if err != nil {
+		fail(err)
+	}
+
+	if *jsonOut {
+		jsonAsBytes, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(jsonAsBytes))
+		return
+	}
+	printReport(report)
+}
+
+func override(value *int, flagValue int) {
+	if flagValue > 0 {
+		*value = flagValue
+	}
+}
+
+func overrideRate(value *float64, flagValue float64) {
+	if flagValue >= 0 {
+		*value = flagValue
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(2)
+}
+
+// ============================================================================================================================
+// Print Report - one line per function, slowest total time first
+// ============================================================================================================================
+func printReport(report Report) {
+	w := report.Workload
+	fmt.Printf("%d users, %d marbles, %d ops (open %.2f close %.2f transfer %.2f query %.2f), seed %d, preset %s\n",
+		w.Users, w.Marbles, w.Ops, w.Open, w.Close, w.Transfer, w.Query, report.Seed, report.Preset)
+	fmt.Printf("%-14s %7s %6s %10s %10s %10s %10s %8s %8s %8s %12s\n", "function", "calls", "errors", "mean", "p50", "p95", "max", "gets", "puts", "dels", "bytes put")
+	for _, fn := range report.Functions{
+		fmt.Printf("%-14s %7d %6d %10s %10s %10s %10s %8.1f %8.1f %8.1f %12.0f\n", fn.Function, fn.Calls, fn.Errors,
+			micros(fn.MeanMicros), micros(fn.P50Micros), micros(fn.P95Micros), micros(fn.MaxMicros),
+			perCall(fn.Gets, fn.Calls), perCall(fn.Puts, fn.Calls), perCall(fn.Dels, fn.Calls), perCall(fn.BytesPut, fn.Calls))
+	}
+	fmt.Printf("gets, puts, dels and bytes put are per call. %d keys, %d bytes in the ledger at the end, %s total\n", report.Keys, report.LedgerBytes, time.Duration(report.TotalMicros) * time.Microsecond)
+}
+
+func micros(us int64) string {
+	return (time.Duration(us) * time.Microsecond).String()
+}
+
+func perCall(total int64, calls int) float64 {
+	if calls == 0 {
+		return 0
+	}
+	return float64(total) / float64(calls)
+}
+
+// ============================================================================================================================
+// Summarize - turn the samples of each function into report lines, slowest total time first
+// ============================================================================================================================
+func summarize(byFunction map[string]*samples) []FunctionReport {
+	var list []FunctionReport
+	for name, s := range byFunction{
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		var total time.Duration
+		for _, latency := range s.latencies{
+			total += latency
+		}
+		n := len(s.latencies)
+		list = append(list, FunctionReport{
+			Function: name, Calls: n, Errors: s.errors,
+			MeanMicros: int64(total / time.Duration(n) / time.Microsecond),
+			P50Micros: int64(s.latencies[n * 50 / 100] / time.Microsecond),
+			P95Micros: int64(s.latencies[n * 95 / 100] / time.Microsecond),
+			MaxMicros: int64(s.latencies[n - 1] / time.Microsecond),
+			TotalMicros: int64(total / time.Microsecond),
+			Gets: s.counts.Gets, Puts: s.counts.Puts, Dels: s.counts.Dels, BytesRead: s.counts.BytesRead, BytesPut: s.counts.BytesPut,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].TotalMicros > list[j].TotalMicros })
+	return list
+}
@@ -0,0 +1,284 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+type Report struct{
+	Workload Workload `json:"workload"`
+	Seed int64 `json:"seed"`
+	Preset string `json:"preset"`
+	Functions []FunctionReport `json:"functions"`
+	Keys int `json:"keys"`								//keys in the ledger at the end
+	LedgerBytes int64 `json:"ledger_bytes"`				//and the bytes of their values
+	TotalMicros int64 `json:"total_micros"`				//time spent in the chaincode, setup included
+}
+
+type FunctionReport struct{
+	Function string `json:"function"`
+	Calls int `json:"calls"`
+	Errors int `json:"errors"`
+	MeanMicros int64 `json:"mean_micros"`
+	P50Micros int64 `json:"p50_micros"`
+	P95Micros int64 `json:"p95_micros"`
+	MaxMicros int64 `json:"max_micros"`
+	TotalMicros int64 `json:"total_micros"`
+	Gets int64 `json:"gets"`							//totals over every call
+	Puts int64 `json:"puts"`
+	Dels int64 `json:"dels"`
+	BytesRead int64 `json:"bytes_read"`
+	BytesPut int64 `json:"bytes_put"`
+}
+
+// Counts is the state access of one function over a run
+type Counts struct{
+	Gets int64
+	Puts int64
+	Dels int64
+	BytesRead int64
+	BytesPut int64
+}
+
+type samples struct{
+	latencies []time.Duration
+	errors int
+	counts Counts
+}
+
+// countingStub counts the state access of the call in progress into counts
+type countingStub struct{
+	*memstub.Stub
+	counts *Counts
+}
+
+func (s *countingStub) GetState(key string) ([]byte, error) {
+	value, err := s.Stub.GetState(key)
+	s.counts.Gets++
+	s.counts.BytesRead += int64(len(value))
+	return value, err
+}
+
+func (s *countingStub) PutState(key string, value []byte) error {
+	s.counts.Puts++
+	s.counts.BytesPut += int64(len(value))
+	return s.Stub.PutState(key, value)
+}
+
+func (s *countingStub) DelState(key string) error {
+	s.counts.Dels++
+	return s.Stub.DelState(key)
+}
+
+// runner is one run of a workload, with what it knows of the ledger so it can pick calls that should work
+type runner struct{
+	cc *marbles.Chaincode
+	stub *countingStub
+	r *rand.Rand
+	users []string
+	owners map[string]string							//marble name to owner, kept from the transfer events
+	names []string
+	byFunction map[string]*samples
+}
+
+// ============================================================================================================================
+// Run - set up the users and marbles, then do the ops, every chaincode call timed and counted
+// ============================================================================================================================
+func run(cc *marbles.Chaincode, workload Workload, r *rand.Rand) (Report, error) {
+	report := Report{Workload: workload, Seed: *seed, Preset: *presetName}
+	stub := memstub.New()
+	stub.Timestamp = 1464000000000										//runs only repeat if time does
+	rn := &runner{cc: cc, stub: &countingStub{Stub: stub}, r: r, owners: make(map[string]string), byFunction: make(map[string]*samples)}
+
+	setup := [][]string{{"admin", "init", "1", "admin"}}										//each call starts with its signer
+	for i := 0; i < workload.Users; i++{
+		user := "u" + strconv.Itoa(i)
+		rn.users = append(rn.users, user)
+		setup = append(setup, []string{user, "register_user", user, user, "co"})
+		if _, ok := cc.Lookup("mint_tokens"); ok {
+			setup = append(setup, []string{"admin", "mint_tokens", "admin", user, "1000"})
+		}
+	}
+	for i := 0; i < workload.Marbles; i++{
+		name := "m" + strconv.Itoa(i)
+		user := rn.users[i % len(rn.users)]
+		setup = append(setup, []string{user, "init_marble", name, pick(r, colors), strconv.Itoa(sizes[r.Intn(len(sizes))]), user})
+		rn.owners[name] = user
+		rn.names = append(rn.names, name)
+	}
+	for _, call := range setup{
+		if _, err := rn.call(call[0], "invoke", call[1], call[2:]); err != nil {
+			return report, errors.New("setup " + strings.Join(call[1:], " ") + ": " + err.Error())
+		}
+	}
+
+	total := workload.Open + workload.Close + workload.Transfer + workload.Query
+	for op := 0; op < workload.Ops; op++{
+		switch n := r.Float64() * total; {
+		case n < workload.Open:
+			rn.openTrade()
+		case n < workload.Open + workload.Close:
+			rn.performTrade()
+		case n < workload.Open + workload.Close + workload.Transfer:
+			name := pick(r, rn.names)
+			rn.call(rn.owners[name], "invoke", "set_user", []string{name, pick(r, rn.users)})
+		default:
+			rn.query()
+		}
+	}
+
+	report.Functions = summarize(rn.byFunction)
+	for _, fn := range report.Functions{
+		report.TotalMicros += fn.TotalMicros
+	}
+	for _, value := range stub.Snapshot(){
+		report.Keys++
+		report.LedgerBytes += int64(len(value))
+	}
+	return report, nil
+}
+
+// ============================================================================================================================
+// Call - run one transaction signed by caller a second after the last, a failed invoke is rolled back like the peer would
+// ============================================================================================================================
+func (rn *runner) call(caller string, kind string, function string, args []string) ([]byte, error) {
+	s, ok := rn.byFunction[function]
+	if !ok {
+		s = &samples{}
+		rn.byFunction[function] = s
+	}
+	rn.stub.Timestamp += 1000
+	rn.stub.Caller = caller
+	rn.stub.ClearEvents()
+	var before map[string]string
+	if kind != "query" {
+		before = rn.stub.Snapshot()										//not counted, the peer does this for free
+	}
+
+	rn.stub.counts = &s.counts
+	start := time.Now()
+	var res []byte
+	var err error
+	if kind == "query" {
+		res, err = rn.cc.Read(rn.stub, function, args)
+	} else {
+		res, err = rn.cc.Invoke(rn.stub, function, args)
+	}
+	s.latencies = append(s.latencies, time.Since(start))
+	rn.stub.counts = &Counts{}
+
+	if err != nil {
+		s.errors++
+		if kind != "query" {
+			rn.stub.Restore(before)
+		}
+		return res, err
+	}
+	for _, event := range rn.stub.Events{
+		if event.Name == "marble_transferred" {
+			var transfer marbles.TransferEvent
+			json.Unmarshal(event.Payload, &transfer)
+			rn.owners[transfer.Name] = transfer.To
+		}
+	}
+	return res, nil
+}
+
+// ============================================================================================================================
+// Open Trade - a user wants a random marble and is willing to give one of their own
+// ============================================================================================================================
+func (rn *runner) openTrade() {
+	name := pick(rn.r, rn.names)
+	user := rn.owners[name]
+	marble := rn.marble(name)
+	args := []string{user, pick(rn.r, colors), strconv.Itoa(sizes[rn.r.Intn(len(sizes))]), marble.Color, strconv.Itoa(marble.Size)}
+	rn.call(user, "invoke", "open_trade", args)
+}
+
+// ============================================================================================================================
+// Perform Trade - someone else closes an open trade, with a marble it wants if one turns up
+// ============================================================================================================================
+func (rn *runner) performTrade() {
+	var trades marbles.AllTrades
+	tradesAsBytes, _ := rn.stub.Stub.GetState("_opentrades")			//outside the counts, like marble
+	json.Unmarshal(tradesAsBytes, &trades)
+	if len(trades.OpenTrades) == 0 {
+		rn.openTrade()
+		return
+	}
+	trade := trades.OpenTrades[rn.r.Intn(len(trades.OpenTrades))]
+	id := strconv.FormatInt(trade.Timestamp, 10)
+
+	closerMarble := ""
+	for try := 0; try < 20; try++{
+		name := pick(rn.r, rn.names)
+		if normalize(rn.owners[name]) == normalize(trade.User) {
+			continue
+		}
+		closerMarble = name
+		marble := rn.marble(name)
+		if marble.Color == trade.Want.Color && marble.Size == trade.Want.Size {
+			break
+		}
+	}
+	if closerMarble == "" {
+		return
+	}
+	rn.call(rn.owners[closerMarble], "invoke", "perform_trade", []string{id, closerMarble, "0"})
+}
+
+// ============================================================================================================================
+// Query - a page of marbles, a page of trades or the stats
+// ============================================================================================================================
+func (rn *runner) query() {
+	switch rn.r.Intn(3) {
+	case 0:
+		rn.call(pick(rn.r, rn.users), "query", "list_marbles", []string{`{"page_size": 20, "filter": {"user": "` + pick(rn.r, rn.users) + `"}}`})
+	case 1:
+		rn.call(pick(rn.r, rn.users), "query", "list_trades", []string{`{"page_size": 20}`})
+	default:
+		rn.call(pick(rn.r, rn.users), "query", "stats", []string{"7", "5"})
+	}
+}
+
+// marble reads a marble outside the counts, the runner has to look too
+func (rn *runner) marble(name string) marbles.Marble {
+	var marble marbles.Marble
+	valueAsBytes, _ := rn.stub.Stub.GetState(name)
+	json.Unmarshal(valueAsBytes, &marble)
+	return marble
+}
+
+func normalize(user string) string {
+	return strings.ToLower(strings.TrimSpace(user))
+}
+
+func pick(r *rand.Rand, list []string) string {
+	return list[r.Intn(len(list))]
+}
@@ -0,0 +1,183 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command marbles runs the marbles chaincode without a peer, against state kept in a JSON file
+//
+//	marbles [-state marbles.json] [-preset part2] [-as bob] [-dry] [-v] invoke init_marble m1 blue 16 bob
+//	marbles query list_marbles '{"page_size": 10}'
+//	marbles functions
+//	marbles dump
+package main
+
+import (
+	"errors"
+	"fmt"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+	"github.com/binhn/marbles-chaincode/txlog"
+)
+
+var statePath = flag.String("state", "marbles.json", "JSON file holding the ledger state")
+var presetName = flag.String("preset", "part2", "which functions are switched on: part1, part2 or experimental")
+var caller = flag.String("as", "", "user who signs the transaction, what a peer would read from its certificate")
+var dry = flag.Bool("dry", false, "show what an invoke would change without saving it")
+var verbose = flag.Bool("v", false, "show the chaincode's own logging on stderr")
+var recordPath = flag.String("record", "", "append every invoke and query to this transaction log, for cmd/replay")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: marbles [flags] invoke|query <function> [args...]")
+		fmt.Fprintln(os.Stderr, "       marbles [flags] functions|dump")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	err := run(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	preset, ok := marbles.FindPreset(*presetName)
+	if !ok {
+		return errors.New("Unknown preset " + *presetName)
+	}
+	cc := marbles.NewChaincode(preset)
+
+	switch args[0] {
+	case "functions":
+		for _, fn := range cc.Functions(){
+			fmt.Printf("%-7s %-22s %-50s %s\n", fn.Kind, fn.Name, strings.Join(fn.Args, " "), fn.Doc)
+		}
+		return nil
+	case "dump":
+		stub, err := memstub.Open(*statePath)
+		if err != nil {
+			return err
+		}
+		snapshot := stub.Snapshot()
+		for _, key := range stub.Keys(){
+			fmt.Printf("%s = %s\n", key, snapshot[key])
+		}
+		return nil
+	case "invoke", "query":
+		if len(args) < 2 {
+			return errors.New("Expecting the name of the function to " + args[0])
+		}
+	default:
+		return errors.New("Unknown command " + args[0])
+	}
+
+	stub, err := memstub.Open(*statePath)
+	if err != nil {
+		return err
+	}
+	stub.Caller = *caller
+	stub.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)		//stamped like a peer stamps a transaction
+	before := stub.Snapshot()
+
+	invoke, read := cc.Invoke, cc.Read
+	if *recordPath != "" && !*dry {
+		recorder, logFile, err := txlog.OpenRecorder(cc, *recordPath, stub)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		invoke, read = recorder.Invoke, recorder.Read
+	}
+
+	var res []byte
+	quiet(func(){
+		if args[0] == "invoke" {
+			res, err = invoke(stub, args[1], args[2:])
+		} else {
+			res, err = read(stub, args[1], args[2:])
+		}
+	})
+	if err != nil {
+		return err																//a failed invoke is not saved
+	}
+	if len(res) > 0 {
+		fmt.Println(string(res))
+	}
+	if args[0] == "query" {
+		return nil
+	}
+
+	for _, event := range stub.Events{
+		fmt.Printf("event %s %s\n", event.Name, event.Payload)
+	}
+	printDiff(memstub.Diff(before, stub.Snapshot()))
+	if *dry {
+		fmt.Println("(dry run, nothing saved)")
+		return nil
+	}
+	return stub.Save()
+}
+
+// ============================================================================================================================
+// Quiet - run f with the chaincode's logging sent to stderr under -v, or nowhere
+// ============================================================================================================================
+func quiet(f func()) {
+	stdout := os.Stdout
+	if *verbose {
+		os.Stdout = os.Stderr
+	} else {
+		devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err == nil {
+			defer devnull.Close()
+			os.Stdout = devnull
+		}
+	}
+	defer func(){ os.Stdout = stdout }()
+	f()
+}
+
+// ============================================================================================================================
+// Print Diff - show the keys an invoke changed
+// ============================================================================================================================
+func printDiff(writes []marbles.StateWrite) {
+	if len(writes) == 0 {
+		fmt.Println("no state changes")
+		return
+	}
+	for _, write := range writes{
+		if write.Deleted {
+			fmt.Printf("- %s\n", write.Key)
+			fmt.Printf("    was: %s\n", write.Before)
+		} else if write.Before == "" {
+			fmt.Printf("+ %s\n", write.Key)
+			fmt.Printf("    now: %s\n", write.After)
+		} else {
+			fmt.Printf("~ %s\n", write.Key)
+			fmt.Printf("    was: %s\n", write.Before)
+			fmt.Printf("    now: %s\n", write.After)
+		}
+	}
+}
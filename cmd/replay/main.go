@@ -0,0 +1,135 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command replay re-runs a transaction log recorded with -record against a fresh ledger and reports the first
+// transaction that does something different this time
+//
+//	replay [-preset part2] [-bisect] tx.jsonl
+package main
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/txlog"
+)
+
+var presetName = flag.String("preset", "part2", "which functions are switched on: part1, part2 or experimental")
+var bisect = flag.Bool("bisect", false, "only compare the chained hashes, binary searching for the first transaction that differs")
+var verbose = flag.Bool("v", false, "show the chaincode's own logging on stderr")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] tx.jsonl")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	diverged, err := run(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	if diverged {
+		os.Exit(1)
+	}
+}
+
+func run(path string) (bool, error) {
+	preset, ok := marbles.FindPreset(*presetName)
+	if !ok {
+		return false, errors.New("Unknown preset " + *presetName)
+	}
+	cc := marbles.NewChaincode(preset)
+
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	entries, err := txlog.ReadLog(in)
+	in.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if *bisect {
+		var index int
+		quiet(func(){ index, err = txlog.Bisect(cc, entries) })
+		if err != nil {
+			return false, err
+		}
+		if index < 0 {
+			fmt.Printf("%d transactions, every hash matches\n", len(entries))
+			return false, nil
+		}
+		fmt.Printf("first transaction with a different hash: #%d %s %s\n", entries[index].Seq, entries[index].Function, strings.Join(entries[index].Args, " "))
+		return true, nil
+	}
+
+	var divergence *txlog.Divergence
+	quiet(func(){ _, divergence, err = txlog.Replay(cc, entries) })
+	if err != nil {
+		return false, err
+	}
+	if divergence == nil {
+		fmt.Printf("%d transactions replayed, every write set matches\n", len(entries))
+		return false, nil
+	}
+
+	recorded := divergence.Recorded
+	fmt.Printf("transaction #%d %s %s diverged: %s\n", recorded.Seq, recorded.Function, strings.Join(recorded.Args, " "), divergence.Reason)
+	printEntry("recorded", recorded)
+	printEntry("replayed", divergence.Replayed)
+	return true, nil
+}
+
+func printEntry(label string, entry txlog.Entry) {
+	jsonAsBytes, _ := json.MarshalIndent(struct{
+		Result string `json:"result"`
+		Error string `json:"error"`
+		Writes []txlog.Write `json:"writes"`
+	}{entry.Result, entry.Error, entry.Writes}, "  ", "  ")
+	fmt.Printf("%s:\n  %s\n", label, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Quiet - run f with the chaincode's logging sent to stderr under -v, or nowhere
+// ============================================================================================================================
+func quiet(f func()) {
+	stdout := os.Stdout
+	if *verbose {
+		os.Stdout = os.Stderr
+	} else {
+		devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err == nil {
+			defer devnull.Close()
+			os.Stdout = devnull
+		}
+	}
+	defer func(){ os.Stdout = stdout }()
+	f()
+}
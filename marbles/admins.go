@@ -0,0 +1,242 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+)
+
+var adminsStr = "_admins"						//name for the key/value that will store the list of admin users
+var adminProposalsStr = "_adminproposals"		//name for the key/value that will store admin changes waiting on a quorum
+
+type AdminProposal struct{
+	Action string `json:"action"`				//"add" or "remove"
+	Target string `json:"target"`				//user being added or removed
+	Approvals []string `json:"approvals"`		//admins who have voted for it
+	Timestamp int64 `json:"timestamp"`			//utc timestamp of the first vote
+}
+
+type AdminProposals struct{
+	Proposals []AdminProposal `json:"proposals"`
+}
+
+type AdminReport struct{
+	Admins []string `json:"admins"`
+	Quorum int `json:"quorum"`
+	Proposals []AdminProposal `json:"proposals"`
+}
+
+// ============================================================================================================================
+// Get Admins - read the list of admin users
+// ============================================================================================================================
+func getAdmins(stub Stub) ([]string, error) {
+	adminsAsBytes, err := stub.GetState(adminsStr)
+	if err != nil {
+		return nil, errors.New("Failed to get admins")
+	}
+	var admins []string
+	json.Unmarshal(adminsAsBytes, &admins)										//un stringify it aka JSON.parse()
+	return admins, nil
+}
+
+// ============================================================================================================================
+// Put Admins - rewrite the list of admin users
+// ============================================================================================================================
+func putAdmins(stub Stub, admins []string) error {
+	jsonAsBytes, _ := json.Marshal(admins)
+	return stub.PutState(adminsStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Is Admin - true if this user is in the admin list and signed the transaction, naming an admin is not enough
+// ============================================================================================================================
+func isAdmin(stub Stub, user string) bool {
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return false
+	}
+	return contains(admins, normalizeUser(user)) && checkSigner(stub, user) == nil
+}
+
+// ============================================================================================================================
+// Contains - true if the list has this string
+// ============================================================================================================================
+func contains(list []string, value string) bool {
+	for i := range list{
+		if list[i] == value{
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// Quorum - how many admin votes it takes to change the admin list, a simple majority
+// ============================================================================================================================
+func quorum(admins []string) int {
+	return len(admins) / 2 + 1
+}
+
+// ============================================================================================================================
+// Vote Admin Change - record an admin's vote to add or remove an admin, apply it once a quorum agrees
+// ============================================================================================================================
+func voteAdminChange(stub Stub, action string, voter string, target string) error {
+	voter = normalizeUser(voter)
+	target = normalizeUser(target)
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return err
+	}
+	if !contains(admins, voter) {
+		return errors.New(voter + " is not an admin")
+	}
+	err = checkSigner(stub, voter)												//one signer, one vote
+	if err != nil {
+		return err
+	}
+	if len(target) <= 0 {
+		return errors.New("2nd argument must be a non-empty string")
+	}
+	if action == "add" && contains(admins, target) {
+		return errors.New(target + " is already an admin")
+	}
+	if action == "remove" && !contains(admins, target) {
+		return errors.New(target + " is not an admin")
+	}
+	if action == "remove" && len(admins) == 1 {
+		return errors.New("Cannot remove the last admin")
+	}
+
+	proposalsAsBytes, err := stub.GetState(adminProposalsStr)
+	if err != nil {
+		return errors.New("Failed to get admin proposals")
+	}
+	var proposals AdminProposals
+	json.Unmarshal(proposalsAsBytes, &proposals)								//un stringify it aka JSON.parse()
+
+	pos := -1
+	for i := range proposals.Proposals{
+		if proposals.Proposals[i].Action == action && proposals.Proposals[i].Target == target{
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {																//first vote opens the proposal
+		proposals.Proposals = append(proposals.Proposals, AdminProposal{Action: action, Target: target, Timestamp: makeTimestamp(stub)})
+		pos = len(proposals.Proposals) - 1
+	}
+	var approvals []string
+	for _, approver := range proposals.Proposals[pos].Approvals{				//drop votes from anyone no longer an admin
+		if contains(admins, approver) {
+			approvals = append(approvals, approver)
+		}
+	}
+	if contains(approvals, voter) {
+		return errors.New(voter + " already voted to " + action + " " + target)
+	}
+	approvals = append(approvals, voter)
+	proposals.Proposals[pos].Approvals = approvals
+	fmt.Println("! " + strconv.Itoa(len(approvals)) + " of " + strconv.Itoa(quorum(admins)) + " votes to " + action + " " + target)
+
+	if len(approvals) >= quorum(admins) {
+		fmt.Println("! quorum reached, applying")
+		if action == "add" {
+			admins = append(admins, target)
+		} else {
+			var kept []string
+			for i := range admins{
+				if admins[i] != target{
+					kept = append(kept, admins[i])
+				}
+			}
+			admins = kept
+		}
+		err = putAdmins(stub, admins)
+		if err != nil {
+			return err
+		}
+		proposals.Proposals = append(proposals.Proposals[:pos], proposals.Proposals[pos+1:]...)
+	}
+
+	jsonAsBytes, _ := json.Marshal(proposals)
+	return stub.PutState(adminProposalsStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Add Admin - vote to make a user an admin
+// ============================================================================================================================
+func (t *Chaincode) add_admin(stub Stub, args []string) ([]byte, error) {
+
+	//   0        1
+	// "admin", "bob"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start add admin")
+	err := voteAdminChange(stub, "add", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end add admin")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Remove Admin - vote to take a user out of the admins
+// ============================================================================================================================
+func (t *Chaincode) remove_admin(stub Stub, args []string) ([]byte, error) {
+
+	//   0        1
+	// "admin", "bob"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start remove admin")
+	err := voteAdminChange(stub, "remove", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end remove admin")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Admins - read the admins, the quorum and any changes still waiting on votes
+// ============================================================================================================================
+func (t *Chaincode) get_admins(stub Stub, args []string) ([]byte, error) {
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	proposalsAsBytes, err := stub.GetState(adminProposalsStr)
+	if err != nil {
+		return nil, errors.New("Failed to get admin proposals")
+	}
+	var proposals AdminProposals
+	json.Unmarshal(proposalsAsBytes, &proposals)								//un stringify it aka JSON.parse()
+
+	jsonAsBytes, _ := json.Marshal(AdminReport{Admins: admins, Quorum: quorum(admins), Proposals: proposals.Proposals})
+	return jsonAsBytes, nil
+}
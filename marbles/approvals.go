@@ -0,0 +1,312 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"strings"
+)
+
+var approvalRulesStr = "_approvalrules"			//name for the key/value that will store which transfers need approval
+var pendingTransfersStr = "_pendingtransfers"	//name for the key/value that will store transfers waiting on approvers
+
+type ApprovalRule struct{
+	MinSize int `json:"min_size"`				//applies to marbles this size or bigger
+	Color string `json:"color"`					//applies to this color only, empty for any color
+	Approvers []string `json:"approvers"`		//users who may approve
+	Required int `json:"required"`				//how many of them must approve
+}
+
+type ApprovalRules struct{
+	Rules []ApprovalRule `json:"rules"`
+}
+
+type PendingTransfer struct{
+	ID int64 `json:"id"`						//utc timestamp of the request, used as an ID
+	Function string `json:"function"`			//"set_user" or "perform_trade"
+	Args []string `json:"args"`				//args to run the function with once approved
+	Marbles []Marble `json:"marbles"`			//the marbles as they were when requested
+	OpenerMarble string `json:"opener_marble,omitempty"`	//perform_trade - the opener's marble the approvers saw, settled with exactly this one
+	Price int `json:"price,omitempty"`			//perform_trade - tokens and fee as they were when requested
+	Fee int `json:"fee,omitempty"`
+	FeePayer string `json:"fee_payer,omitempty"`
+	Approvers []string `json:"approvers"`
+	Required int `json:"required"`
+	Approvals []string `json:"approvals"`
+}
+
+type PendingTransfers struct{
+	Transfers []PendingTransfer `json:"transfers"`
+}
+
+// ============================================================================================================================
+// Get Pending Transfers - read the transfers waiting on approvers
+// ============================================================================================================================
+func getPendingTransfers(stub Stub) (PendingTransfers, error) {
+	var pending PendingTransfers
+	pendingAsBytes, err := stub.GetState(pendingTransfersStr)
+	if err != nil {
+		return pending, errors.New("Failed to get pending transfers")
+	}
+	json.Unmarshal(pendingAsBytes, &pending)									//un stringify it aka JSON.parse()
+	return pending, nil
+}
+
+// ============================================================================================================================
+// Put Pending Transfers - rewrite the transfers waiting on approvers
+// ============================================================================================================================
+func putPendingTransfers(stub Stub, pending PendingTransfers) error {
+	jsonAsBytes, _ := json.Marshal(pending)
+	return stub.PutState(pendingTransfersStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Request Approval - if any of the request's marbles falls under a rule, park it as a pending transfer and return its ID
+// ============================================================================================================================
+func requestApproval(stub Stub, request PendingTransfer) (string, error) {
+	rulesAsBytes, err := stub.GetState(approvalRulesStr)
+	if err != nil {
+		return "", errors.New("Failed to get approval rules")
+	}
+	var rules ApprovalRules
+	json.Unmarshal(rulesAsBytes, &rules)										//un stringify it aka JSON.parse()
+
+	var rule *ApprovalRule
+	for i := range rules.Rules{													//the strictest matching rule wins
+		for x := range request.Marbles{
+			if request.Marbles[x].Name == "" || request.Marbles[x].Size < rules.Rules[i].MinSize {
+				continue
+			}
+			if rules.Rules[i].Color != "" && rules.Rules[i].Color != strings.ToLower(request.Marbles[x].Color) {
+				continue
+			}
+			if rule == nil || rules.Rules[i].Required > rule.Required {
+				rule = &rules.Rules[i]
+			}
+		}
+	}
+	if rule == nil {
+		return "", nil
+	}
+
+	pending, err := getPendingTransfers(stub)
+	if err != nil {
+		return "", err
+	}
+	transfer := request
+	transfer.ID = makeTimestamp(stub)
+	transfer.Approvers = rule.Approvers
+	transfer.Required = rule.Required
+	transfer.Marbles = nil
+	for i := range pending.Transfers{											//several in one invoke share a timestamp, keep IDs unique
+		if pending.Transfers[i].ID >= transfer.ID {
+			transfer.ID = pending.Transfers[i].ID + 1
+		}
+	}
+	for x := range request.Marbles{
+		if request.Marbles[x].Name != "" {
+			transfer.Marbles = append(transfer.Marbles, request.Marbles[x])
+		}
+	}
+	pending.Transfers = append(pending.Transfers, transfer)
+	err = putPendingTransfers(stub, pending)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(transfer.ID, 10), nil
+}
+
+// ============================================================================================================================
+// Find Pending Transfer - look up a pending transfer by ID, check the approver signed and may act on it
+// ============================================================================================================================
+func findPendingTransfer(stub Stub, pending PendingTransfers, id string, approver string) (int, error) {
+	timestamp, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return -1, errors.New("1st argument must be a numeric string")
+	}
+	err = checkSigner(stub, approver)
+	if err != nil {
+		return -1, err
+	}
+	for i := range pending.Transfers{
+		if pending.Transfers[i].ID == timestamp{
+			if !contains(pending.Transfers[i].Approvers, normalizeUser(approver)) {
+				return -1, errors.New(approver + " is not an approver for this transfer")
+			}
+			return i, nil
+		}
+	}
+	return -1, errors.New("No pending transfer " + id)
+}
+
+// ============================================================================================================================
+// Set Approval Rules - admin replaces the approval rules with a JSON document
+// ============================================================================================================================
+func (t *Chaincode) set_approval_rules(stub Stub, args []string) ([]byte, error) {
+
+	//   0         1
+	// "admin", "{"rules": [{"min_size": 35, "approvers": ["alice", "carol"], "required": 2}]}"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start set approval rules")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	var rules ApprovalRules
+	err := json.Unmarshal([]byte(args[1]), &rules)
+	if err != nil {
+		return nil, errors.New("2nd argument must be a JSON list of approval rules")
+	}
+	for i := range rules.Rules{
+		if rules.Rules[i].Required <= 0 || rules.Rules[i].Required > len(rules.Rules[i].Approvers) {
+			return nil, errors.New("Rule " + strconv.Itoa(i) + " must require between 1 and its number of approvers")
+		}
+		rules.Rules[i].Color = strings.ToLower(rules.Rules[i].Color)
+		for x := range rules.Rules[i].Approvers{
+			rules.Rules[i].Approvers[x] = normalizeUser(rules.Rules[i].Approvers[x])
+		}
+	}
+
+	jsonAsBytes, _ := json.Marshal(rules)
+	err = stub.PutState(approvalRulesStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set approval rules")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Approve Transfer - an approver signs off, once enough have the transfer runs
+// ============================================================================================================================
+func (t *Chaincode) approve_transfer(stub Stub, args []string) ([]byte, error) {
+
+	//   0       1
+	// "id", "alice"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start approve transfer")
+	pending, err := getPendingTransfers(stub)
+	if err != nil {
+		return nil, err
+	}
+	i, err := findPendingTransfer(stub, pending, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	approver := normalizeUser(args[1])
+	if contains(pending.Transfers[i].Approvals, approver) {
+		return nil, errors.New(approver + " already approved this transfer")
+	}
+	pending.Transfers[i].Approvals = append(pending.Transfers[i].Approvals, approver)
+
+	transfer := pending.Transfers[i]
+	if len(transfer.Approvals) >= transfer.Required {
+		fmt.Println("! enough approvals, running " + transfer.Function)
+		pending.Transfers = append(pending.Transfers[:i], pending.Transfers[i+1:]...)
+		for x := range transfer.Marbles{											//nothing may have changed hands while we waited
+			current, err := getMarble(stub, transfer.Marbles[x].Name)
+			if err != nil {
+				return nil, err
+			}
+			if current.User != transfer.Marbles[x].User || current.Status != "" {
+				return nil, errors.New("Marble " + current.Name + " changed since the transfer was requested, reject it instead")
+			}
+		}
+		if transfer.Function == "set_user" {
+			err = transferMarble(stub, transfer.Args[0], transfer.Args[1])
+		} else {
+			_, err = t.performTrade(stub, transfer.Args, &transfer)					//settle exactly what was approved
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = putPendingTransfers(stub, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end approve transfer")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Reject Transfer - an approver cancels a pending transfer
+// ============================================================================================================================
+func (t *Chaincode) reject_transfer(stub Stub, args []string) ([]byte, error) {
+
+	//   0       1
+	// "id", "alice"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start reject transfer")
+	pending, err := getPendingTransfers(stub)
+	if err != nil {
+		return nil, err
+	}
+	i, err := findPendingTransfer(stub, pending, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	pending.Transfers = append(pending.Transfers[:i], pending.Transfers[i+1:]...)
+	err = putPendingTransfers(stub, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end reject transfer")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Pending Transfers - read the transfers waiting on approvers, optionally only those waiting on one approver
+// ============================================================================================================================
+func (t *Chaincode) pending_transfers(stub Stub, args []string) ([]byte, error) {
+
+	//     0
+	// *"alice"*
+	pending, err := getPendingTransfers(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 {
+		approver := normalizeUser(args[0])
+		var waiting []PendingTransfer
+		for i := range pending.Transfers{
+			if contains(pending.Transfers[i].Approvers, approver) && !contains(pending.Transfers[i].Approvals, approver) {
+				waiting = append(waiting, pending.Transfers[i])
+			}
+		}
+		pending.Transfers = waiting
+	}
+	jsonAsBytes, _ := json.Marshal(pending)
+	return jsonAsBytes, nil
+}
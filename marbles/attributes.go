@@ -0,0 +1,283 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+var attributeSchemaStr = "_attrschema"			//name for the key/value that will store the marble attribute schema
+
+var maxAttributeKey = 64						//longest attribute name allowed without a schema
+var maxAttributeValue = 256						//longest attribute value allowed without a schema
+
+type AttributeRule struct{
+	Type string `json:"type"`					//"string", "int", "bool" or "sha256"
+	Required bool `json:"required"`				//must be set on every marble
+	MaxLength int `json:"max_length"`			//longest value allowed, 0 for the default
+}
+
+type AttributeSchema struct{
+	Attributes map[string]AttributeRule `json:"attributes"`	//attribute name -> rule, only these names are allowed once any are listed
+}
+
+type MarbleQuery struct{
+	Color string `json:"color"`					//empty matches any color
+	Size int `json:"size"`						//0 matches any size
+	User string `json:"user"`					//empty matches any owner
+	Attributes map[string]string `json:"attributes"`	//every listed attribute must match
+}
+
+type TradeOptions struct{
+	Want map[string]string `json:"want"`				//attributes the wanted marble must have
+	Willing []map[string]string `json:"willing"`		//attributes for each willing marble, in order
+}
+
+// ============================================================================================================================
+// Get Attribute Schema - read the attribute schema, an empty schema allows any attribute
+// ============================================================================================================================
+func getAttributeSchema(stub Stub) (AttributeSchema, error) {
+	var schema AttributeSchema
+	schemaAsBytes, err := stub.GetState(attributeSchemaStr)
+	if err != nil {
+		return schema, errors.New("Failed to get attribute schema")
+	}
+	json.Unmarshal(schemaAsBytes, &schema)										//un stringify it aka JSON.parse()
+	return schema, nil
+}
+
+// ============================================================================================================================
+// Validate Attributes - errors if a marble's attributes do not fit the schema
+// ============================================================================================================================
+func validateAttributes(schema AttributeSchema, attributes map[string]string) error {
+	for key, value := range attributes{
+		if len(key) == 0 || len(key) > maxAttributeKey {
+			return errors.New("Attribute names must be 1 to " + strconv.Itoa(maxAttributeKey) + " characters")
+		}
+		if len(schema.Attributes) == 0 {										//no schema, just keep it sane
+			if len(value) > maxAttributeValue {
+				return errors.New("Attribute " + key + " is longer than " + strconv.Itoa(maxAttributeValue) + " characters")
+			}
+			continue
+		}
+
+		rule, ok := schema.Attributes[key]
+		if !ok {
+			return errors.New("Attribute " + key + " is not in the schema")
+		}
+		limit := rule.MaxLength
+		if limit <= 0 {
+			limit = maxAttributeValue
+		}
+		if len(value) > limit {
+			return errors.New("Attribute " + key + " is longer than " + strconv.Itoa(limit) + " characters")
+		}
+		if rule.Type == "int" {
+			if _, err := strconv.Atoi(value); err != nil {
+				return errors.New("Attribute " + key + " must be an integer")
+			}
+		} else if rule.Type == "bool" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return errors.New("Attribute " + key + " must be true or false")
+			}
+		} else if rule.Type == "sha256" {
+			if b, err := hex.DecodeString(value); err != nil || len(b) != 32 {
+				return errors.New("Attribute " + key + " must be a hex sha256 hash")
+			}
+		}
+	}
+	for key, rule := range schema.Attributes{
+		if _, ok := attributes[key]; rule.Required && !ok {
+			return errors.New("Attribute " + key + " is required")
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Parse Attributes - read a JSON object of attributes from an argument, empty string means none
+// ============================================================================================================================
+func parseAttributes(arg string) (map[string]string, error) {
+	attributes := make(map[string]string)
+	if len(arg) == 0 {
+		return attributes, nil
+	}
+	err := json.Unmarshal([]byte(arg), &attributes)
+	if err != nil {
+		return nil, errors.New("Attributes must be a JSON object of strings")
+	}
+	return attributes, nil
+}
+
+// ============================================================================================================================
+// Has Attributes - true if the marble has every one of these attributes with the same value
+// ============================================================================================================================
+func hasAttributes(marble Marble, attributes map[string]string) bool {
+	for key, value := range attributes{
+		if marble.Attributes[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================================================================================
+// Matches Description - true if the marble is the color, size and attributes described
+// ============================================================================================================================
+func matchesDescription(marble Marble, desc Description) bool {
+	return strings.ToLower(marble.Color) == strings.ToLower(desc.Color) && marble.Size == desc.Size && hasAttributes(marble, desc.Attributes)
+}
+
+// ============================================================================================================================
+// Set Attribute Schema - admin replaces the attribute schema with a JSON document
+// ============================================================================================================================
+func (t *Chaincode) set_attribute_schema(stub Stub, args []string) ([]byte, error) {
+
+	//   0         1
+	// "admin", "{"attributes": {"material": {"type": "string", "required": true}}}"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start set attribute schema")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	var schema AttributeSchema
+	err := json.Unmarshal([]byte(args[1]), &schema)
+	if err != nil {
+		return nil, errors.New("2nd argument must be a JSON attribute schema")
+	}
+	for key, rule := range schema.Attributes{
+		if rule.Type == "" {
+			rule.Type = "string"
+			schema.Attributes[key] = rule
+		}
+		if rule.Type != "string" && rule.Type != "int" && rule.Type != "bool" && rule.Type != "sha256" {
+			return nil, errors.New("Attribute " + key + " has unknown type " + rule.Type)
+		}
+	}
+
+	jsonAsBytes, _ := json.Marshal(schema)
+	err = stub.PutState(attributeSchemaStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set attribute schema")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Update Attributes - owner sets or clears attributes on a marble, an empty value clears it
+// ============================================================================================================================
+func (t *Chaincode) update_attributes(stub Stub, args []string) ([]byte, error) {
+
+	//   0       1        2
+	// "name", "bob", "{"material": "glass", "pattern": ""}"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start update attributes")
+	marble, err := getOwnedMarble(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	changes, err := parseAttributes(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if marble.Attributes == nil {
+		marble.Attributes = make(map[string]string)
+	}
+	for key, value := range changes{
+		if value == "" {
+			delete(marble.Attributes, key)
+		} else {
+			marble.Attributes[key] = value
+		}
+	}
+	schema, err := getAttributeSchema(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = validateAttributes(schema, marble.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putMarble(stub, marble)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end update attributes")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Find Marbles - read every marble that matches a JSON query of color, size, user and attributes
+// ============================================================================================================================
+func (t *Chaincode) find_marbles(stub Stub, args []string) ([]byte, error) {
+
+	//   0
+	// "{"color": "blue", "attributes": {"material": "glass"}}"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	var query MarbleQuery
+	err := json.Unmarshal([]byte(args[0]), &query)
+	if err != nil {
+		return nil, errors.New("1st argument must be a JSON marble query")
+	}
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Marble{}
+	for i := range marbleIndex{
+		marble, err := getMarble(stub, marbleIndex[i])
+		if err != nil {
+			continue
+		}
+		if query.Color != "" && strings.ToLower(marble.Color) != strings.ToLower(query.Color) {
+			continue
+		}
+		if query.Size != 0 && marble.Size != query.Size {
+			continue
+		}
+		if query.User != "" && normalizeUser(marble.User) != normalizeUser(query.User) {
+			continue
+		}
+		if hasAttributes(marble, query.Attributes) {
+			found = append(found, marble)
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(found)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,126 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// ledger sizes every benchmark runs at, named so benchstat lines them up across runs
+var benchSizes = []int{100, 1000}
+
+// ============================================================================================================================
+// Bench Ledger - the setup users holding count marbles round robin, a fifth of them offered in open trades
+// ============================================================================================================================
+func benchLedger(b *testing.B, cc *marbles.Chaincode, count int) *memstub.Stub {
+	stub := setup(b, cc)
+	for i := 0; i < count; i++{
+		user := users[i % len(users)]
+		mustInvoke(b, cc, stub, user, "init_marble", "m" + strconv.Itoa(i), colors[i % len(colors)], sizes[i / len(colors) % len(sizes)], user)
+	}
+	for i := 0; i < count; i += 5{
+		user := users[i % len(users)]
+		mustInvoke(b, cc, stub, user, "open_trade", user, colors[(i + 1) % len(colors)], sizes[0], colors[i % len(colors)], sizes[i / len(colors) % len(sizes)])
+	}
+	return stub
+}
+
+// quiet sends the chaincode's logging nowhere until the returned func is called
+func quiet() func() {
+	stdout := os.Stdout
+	os.Stdout = devnull
+	return func(){
+		os.Stdout = stdout
+	}
+}
+
+// ============================================================================================================================
+// Benchmark Find Marble 4 Trade - a user looking for the last marble they were given, the whole index is read
+// ============================================================================================================================
+func BenchmarkFindMarble4Trade(b *testing.B) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, count := range benchSizes{
+		b.Run("marbles=" + strconv.Itoa(count), func(b *testing.B) {
+			stub := benchLedger(b, cc, count)
+			last := count - 1
+			user := users[last % len(users)]
+			size, _ := strconv.Atoi(sizes[last / len(colors) % len(sizes)])
+			desc := marbles.Description{Color: colors[last % len(colors)], Size: size}
+			defer quiet()()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++{
+				if _, err := marbles.FindMarble4Trade(stub, user, desc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// ============================================================================================================================
+// Benchmark Clean Trades - a full sweep of a ledger with nothing to clean, every option of every trade is looked up
+// ============================================================================================================================
+func BenchmarkCleanTrades(b *testing.B) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, count := range benchSizes{
+		b.Run("marbles=" + strconv.Itoa(count), func(b *testing.B) {
+			stub := benchLedger(b, cc, count)
+			defer quiet()()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++{
+				if err := marbles.CleanTrades(stub, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// ============================================================================================================================
+// Benchmark Perform Trade - close the first open trade through Invoke, incremental clean included, the ledger is put
+// back between runs outside the timer
+// ============================================================================================================================
+func BenchmarkPerformTrade(b *testing.B) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, count := range benchSizes{
+		b.Run("marbles=" + strconv.Itoa(count), func(b *testing.B) {
+			stub := benchLedger(b, cc, count)
+			trades := openTrades(b, cc, stub)
+			id := strconv.FormatInt(trades[0].Timestamp, 10)
+			stub.Caller = users[1]													//m1 is the closer's, the first trade is users[0]'s
+			before := stub.Snapshot()
+			defer quiet()()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++{
+				if _, err := cc.Invoke(stub, "perform_trade", []string{id, "m1", "0"}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				stub.Restore(before)
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+)
+
+type BulkTransfer struct{
+	Name string `json:"name"`					//marble to move
+	User string `json:"user"`					//new owner
+}
+
+type BulkResult struct{
+	Index int `json:"index"`					//position in the request
+	Name string `json:"name"`
+	Status string `json:"status"`				//"valid" or "invalid" when rejected, else "created", "transferred" or "pending"
+	Error string `json:"error,omitempty"`
+	Pending string `json:"pending,omitempty"`	//pending transfer ID when approvers must sign off
+}
+
+// ============================================================================================================================
+// Bulk Failed - a batch with any invalid entry writes nothing, the error carries the whole report
+// ============================================================================================================================
+func bulkFailed(results []BulkResult) error {
+	jsonAsBytes, _ := json.Marshal(results)
+	return errors.New("Batch rejected, nothing was written: " + string(jsonAsBytes))
+}
+
+// ============================================================================================================================
+// Init Marbles - create many marbles at once, every entry is checked before any are written
+// ============================================================================================================================
+func (t *Chaincode) init_marbles(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0                                                            1
+	// "[{"name": "asdf", "color": "blue", "size": 35, "user": "bob"}]", *"minter"*
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start init marbles")
+	var marbles []Marble
+	err = json.Unmarshal([]byte(args[0]), &marbles)
+	if err != nil {
+		return nil, errors.New("1st argument must be a JSON list of marbles")
+	}
+	if len(marbles) == 0 {
+		return nil, errors.New("1st argument must list at least one marble")
+	}
+	minter := ""
+	if len(args) > 1 {
+		minter = args[1]
+	}
+
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := getAttributeSchema(stub)
+	if err != nil {
+		return nil, err
+	}
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for i := range marbleIndex{
+		taken[marbleIndex[i]] = true
+	}
+
+	results := make([]BulkResult, len(marbles))
+	failed := false
+	for i := range marbles{
+		results[i] = BulkResult{Index: i, Name: marbles[i].Name, Status: "valid"}
+		if len(marbles[i].Name) <= 0 || len(marbles[i].Color) <= 0 || len(marbles[i].User) <= 0 {
+			err = errors.New("Marble needs a name, color and user")
+		} else if marbles[i].Status != "" {
+			err = errors.New("Marble must not have a status")
+		} else if taken[marbles[i].Name] {
+			err = errors.New("Marble " + marbles[i].Name + " already exists")
+		} else {
+			marbles[i], err = checkNewMarble(stub, policy, supply, schema, minter, marbles[i])
+		}
+		if err != nil {
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			failed = true
+			continue
+		}
+		taken[marbles[i].Name] = true
+		supply.Total++															//later entries count against the caps too
+		supply.Colors[marbles[i].Color]++
+		supply.Users[marbles[i].User]++
+	}
+	if failed {
+		return nil, bulkFailed(results)
+	}
+
+	for i := range marbles{
+		err = putMarble(stub, marbles[i])										//store marble with id as key
+		if err != nil {
+			return nil, err
+		}
+		marbleIndex = append(marbleIndex, marbles[i].Name)						//add marble name to index list
+		results[i].Status = "created"
+	}
+	err = putMarbleIndex(stub, marbleIndex)									//one index write for the whole batch
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end init marbles")
+	jsonAsBytes, _ := json.Marshal(results)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Transfer Marbles - change the owner of many marbles at once, every entry is checked before any are moved
+// ============================================================================================================================
+func (t *Chaincode) transfer_marbles(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0
+	// "[{"name": "asdf", "user": "alice"}]"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start transfer marbles")
+	var transfers []BulkTransfer
+	err = json.Unmarshal([]byte(args[0]), &transfers)
+	if err != nil {
+		return nil, errors.New("1st argument must be a JSON list of transfers")
+	}
+	if len(transfers) == 0 {
+		return nil, errors.New("1st argument must list at least one transfer")
+	}
+
+	results := make([]BulkResult, len(transfers))
+	marbles := make([]Marble, len(transfers))
+	seen := make(map[string]bool)
+	failed := false
+	for i := range transfers{
+		results[i] = BulkResult{Index: i, Name: transfers[i].Name, Status: "valid"}
+		if seen[transfers[i].Name] {
+			err = errors.New("Marble " + transfers[i].Name + " is listed twice")
+		} else {
+			marbles[i], err = getMarble(stub, transfers[i].Name)
+		}
+		if err == nil && marbles[i].Status != "" {
+			err = errors.New("Marble " + marbles[i].Name + " was " + marbles[i].Status + ", it cannot be transferred")
+		}
+		if err == nil {
+			transfers[i].User, err = checkActiveUser(stub, transfers[i].User)
+		}
+		if err == nil {
+			err = checkCaller(stub, marbles[i].User, marbles[i].Name)			//owner or their operator
+		}
+		if err != nil {
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			failed = true
+			continue
+		}
+		seen[transfers[i].Name] = true
+	}
+	if failed {
+		return nil, bulkFailed(results)
+	}
+
+	for i := range transfers{
+		transferArgs := []string{transfers[i].Name, transfers[i].User}		//same args set_user would have had
+		pending, err := requestApproval(stub, PendingTransfer{Function: "set_user", Args: transferArgs, Marbles: []Marble{marbles[i]}})	//big marbles wait for approvers
+		if err != nil {
+			return nil, err
+		}
+		if pending != "" {
+			results[i].Status = "pending"
+			results[i].Pending = pending
+			continue
+		}
+		err = transferMarble(stub, transfers[i].Name, transfers[i].User)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Status = "transferred"
+	}
+
+	fmt.Println("- end transfer marbles")
+	jsonAsBytes, _ := json.Marshal(results)
+	return jsonAsBytes, nil
+}
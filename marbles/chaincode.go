@@ -0,0 +1,233 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Chaincode is the marbles chaincode with the functions of one preset switched on
+type Chaincode struct {
+	preset Preset
+	functions map[string]Function
+}
+
+type Function struct{
+	Name string `json:"name"`
+	Kind string `json:"kind"`					//"invoke" or "query"
+	Args []string `json:"args"`				//argument names in order, *optional* ones are starred
+	Doc string `json:"doc"`
+	Feature string `json:"feature"`			//presets switch functions on by feature
+	CleanAfter bool `json:"clean_after"`		//open trades of owners whose marbles it changed are cleaned after it runs
+	handler func(t *Chaincode, stub Stub, args []string) ([]byte, error)
+}
+
+type Preset struct{
+	Name string
+	Features []string
+	TradePolicy TradePolicy						//how perform_trade checks trades until an admin sets a policy
+}
+
+var Part1 = Preset{Name: "part1", Features: []string{"core"}}
+var Part2 = Preset{Name: "part2", Features: []string{"core", "trades", "tokens", "lifecycle", "minting", "attributes", "approvals", "operators", "snapshots", "bulk", "listing", "stats"}}
+var Experimental = Preset{Name: "experimental", Features: Part2.Features, TradePolicy: TradePolicy{StrictWant: true}}
+var Presets = []Preset{Part1, Part2, Experimental}
+
+var registry = []Function{
+	{Name: "init", Kind: "invoke", Args: []string{"aval", "admin..."}, Doc: "initialize the chaincode state, used as reset", Feature: "core", handler: (*Chaincode).init},
+	{Name: "delete", Kind: "invoke", Args: []string{"admin", "name"}, Doc: "deletes an entity from its state", Feature: "core", CleanAfter: true, handler: (*Chaincode).Delete},
+	{Name: "write", Kind: "invoke", Args: []string{"admin", "name", "value"}, Doc: "writes a value to the chaincode state", Feature: "core", handler: (*Chaincode).Write},
+	{Name: "init_marble", Kind: "invoke", Args: []string{"name", "color", "size", "user", "*minter*", "*attributes*"}, Doc: "create a new marble", Feature: "core", handler: (*Chaincode).init_marble},
+	{Name: "init_marbles", Kind: "invoke", Args: []string{"marbles", "*minter*"}, Doc: "create many marbles at once", Feature: "bulk", handler: (*Chaincode).init_marbles},
+	{Name: "set_user", Kind: "invoke", Args: []string{"name", "user"}, Doc: "change owner of a marble", Feature: "core", CleanAfter: true, handler: (*Chaincode).set_user},
+	{Name: "transfer_marbles", Kind: "invoke", Args: []string{"transfers"}, Doc: "change owner of many marbles at once", Feature: "bulk", CleanAfter: true, handler: (*Chaincode).transfer_marbles},
+	{Name: "open_trade", Kind: "invoke", Args: []string{"user", "want_color", "want_size", "*willing_color...*", "*willing_size...*", "*price*", "*options*"}, Doc: "create a new trade order", Feature: "trades", handler: (*Chaincode).open_trade},
+	{Name: "perform_trade", Kind: "invoke", Args: []string{"id", "closer_marble", "willing_index"}, Doc: "forfill an open trade order", Feature: "trades", CleanAfter: true, handler: (*Chaincode).perform_trade},
+	{Name: "remove_trade", Kind: "invoke", Args: []string{"id"}, Doc: "cancel an open trade order", Feature: "trades", handler: (*Chaincode).remove_trade},
+	{Name: "mint_tokens", Kind: "invoke", Args: []string{"admin", "user", "amount"}, Doc: "admin creates tokens for a user", Feature: "tokens", handler: (*Chaincode).mint_tokens},
+	{Name: "transfer_tokens", Kind: "invoke", Args: []string{"from", "to", "amount"}, Doc: "move tokens between users", Feature: "tokens", CleanAfter: true, handler: (*Chaincode).transfer_tokens},
+	{Name: "set_fee_schedule", Kind: "invoke", Args: []string{"admin", "mode", "amount", "payer"}, Doc: "admin sets the trade fee", Feature: "tokens", handler: (*Chaincode).set_fee_schedule},
+	{Name: "burn_marble", Kind: "invoke", Args: []string{"name", "user"}, Doc: "destroy a marble", Feature: "lifecycle", CleanAfter: true, handler: (*Chaincode).burn_marble},
+	{Name: "merge_marbles", Kind: "invoke", Args: []string{"name", "absorbed", "user"}, Doc: "combine two marbles into one", Feature: "lifecycle", CleanAfter: true, handler: (*Chaincode).merge_marbles},
+	{Name: "split_marble", Kind: "invoke", Args: []string{"name", "user", "new_name", "size", "*new_name...*", "*size...*"}, Doc: "break a marble into several", Feature: "lifecycle", CleanAfter: true, handler: (*Chaincode).split_marble},
+	{Name: "set_mint_policy", Kind: "invoke", Args: []string{"admin", "policy"}, Doc: "admin replaces the minting policy", Feature: "minting", handler: (*Chaincode).set_mint_policy},
+	{Name: "set_mint_cap", Kind: "invoke", Args: []string{"admin", "kind", "*key*", "value"}, Doc: "admin edits one minting limit", Feature: "minting", handler: (*Chaincode).set_mint_cap},
+	{Name: "set_minter", Kind: "invoke", Args: []string{"admin", "user", "allowed"}, Doc: "admin allows or stops a minter", Feature: "minting", handler: (*Chaincode).set_minter},
+	{Name: "set_attribute_schema", Kind: "invoke", Args: []string{"admin", "schema"}, Doc: "admin sets which marble attributes are allowed", Feature: "attributes", handler: (*Chaincode).set_attribute_schema},
+	{Name: "set_approval_rules", Kind: "invoke", Args: []string{"admin", "rules"}, Doc: "admin sets which transfers need approval", Feature: "approvals", handler: (*Chaincode).set_approval_rules},
+	{Name: "approve_transfer", Kind: "invoke", Args: []string{"id", "approver"}, Doc: "approve a transfer waiting on approvers", Feature: "approvals", CleanAfter: true, handler: (*Chaincode).approve_transfer},
+	{Name: "reject_transfer", Kind: "invoke", Args: []string{"id", "approver"}, Doc: "cancel a transfer waiting on approvers", Feature: "approvals", handler: (*Chaincode).reject_transfer},
+	{Name: "approve_operator", Kind: "invoke", Args: []string{"owner", "operator", "expires", "*marble...*"}, Doc: "let another user act on your marbles", Feature: "operators", handler: (*Chaincode).approve_operator},
+	{Name: "revoke_operator", Kind: "invoke", Args: []string{"owner", "operator"}, Doc: "stop another user acting on your marbles", Feature: "operators", handler: (*Chaincode).revoke_operator},
+	{Name: "import_state", Kind: "invoke", Args: []string{"admin", "mode", "snapshot"}, Doc: "admin loads a snapshot from export_state", Feature: "snapshots", CleanAfter: true, handler: (*Chaincode).import_state},
+	{Name: "add_admin", Kind: "invoke", Args: []string{"admin", "target"}, Doc: "vote to add an admin", Feature: "core", handler: (*Chaincode).add_admin},
+	{Name: "remove_admin", Kind: "invoke", Args: []string{"admin", "target"}, Doc: "vote to remove an admin", Feature: "core", handler: (*Chaincode).remove_admin},
+	{Name: "register_user", Kind: "invoke", Args: []string{"id", "display_name", "company"}, Doc: "create a user record", Feature: "core", handler: (*Chaincode).register_user},
+	{Name: "set_user_status", Kind: "invoke", Args: []string{"admin", "id", "status"}, Doc: "admin suspends or reactivates a user", Feature: "core", handler: (*Chaincode).set_user_status},
+	{Name: "migrate", Kind: "invoke", Args: []string{"admin", "*version*"}, Doc: "admin upgrades the ledger layout", Feature: "core", handler: (*Chaincode).migrate},
+	{Name: "rebuild_stats", Kind: "invoke", Args: []string{"admin"}, Doc: "admin recounts the stats counters", Feature: "stats", handler: (*Chaincode).rebuild_stats},
+	{Name: "clean_trades", Kind: "invoke", Args: []string{"admin"}, Doc: "admin re-checks every open trade", Feature: "trades", handler: (*Chaincode).clean_trades},
+	{Name: "set_trade_policy", Kind: "invoke", Args: []string{"admin", "policy"}, Doc: "admin sets how perform_trade checks trades", Feature: "trades", handler: (*Chaincode).set_trade_policy},
+	{Name: "update_attributes", Kind: "invoke", Args: []string{"name", "user", "attributes"}, Doc: "owner edits a marble's attributes", Feature: "attributes", CleanAfter: true, handler: (*Chaincode).update_attributes},
+
+	{Name: "query", Kind: "query", Args: []string{"key"}, Doc: "read a variable from chaincode state", Feature: "core", handler: (*Chaincode).read},
+	{Name: "balance_of", Kind: "query", Args: []string{"user"}, Doc: "read a user's token balance", Feature: "tokens", handler: (*Chaincode).balance_of},
+	{Name: "fee_report", Kind: "query", Args: []string{"*period*"}, Doc: "read the fees collected per period", Feature: "tokens", handler: (*Chaincode).fee_report},
+	{Name: "marble_history", Kind: "query", Args: []string{"name"}, Doc: "read what happened to a marble", Feature: "lifecycle", handler: (*Chaincode).marble_history},
+	{Name: "supply_stats", Kind: "query", Args: []string{}, Doc: "read marble supply against the mint caps", Feature: "minting", handler: (*Chaincode).supply_stats},
+	{Name: "find_marbles", Kind: "query", Args: []string{"query"}, Doc: "read marbles matching color, size, user and attributes", Feature: "attributes", handler: (*Chaincode).find_marbles},
+	{Name: "get_user", Kind: "query", Args: []string{"id"}, Doc: "read a user record", Feature: "core", handler: (*Chaincode).get_user},
+	{Name: "get_admins", Kind: "query", Args: []string{}, Doc: "read the admins and pending admin votes", Feature: "core", handler: (*Chaincode).get_admins},
+	{Name: "pending_transfers", Kind: "query", Args: []string{"*approver*"}, Doc: "read transfers waiting on approvers", Feature: "approvals", handler: (*Chaincode).pending_transfers},
+	{Name: "get_operators", Kind: "query", Args: []string{"owner"}, Doc: "read who may act for an owner", Feature: "operators", handler: (*Chaincode).get_operators},
+	{Name: "export_state", Kind: "query", Args: []string{}, Doc: "read a snapshot of all marbles, trades and config", Feature: "snapshots", handler: (*Chaincode).export_state},
+	{Name: "list_marbles", Kind: "query", Args: []string{"params"}, Doc: "read a page of marbles, filtered and sorted", Feature: "listing", handler: (*Chaincode).list_marbles},
+	{Name: "list_trades", Kind: "query", Args: []string{"params"}, Doc: "read a page of open trades, filtered and sorted", Feature: "trades", handler: (*Chaincode).list_trades},
+	{Name: "stats", Kind: "query", Args: []string{"*days*", "*top*"}, Doc: "read the marble and trade counters", Feature: "stats", handler: (*Chaincode).stats},
+	{Name: "get_receipt", Kind: "query", Args: []string{"id"}, Doc: "read the receipt of a completed trade", Feature: "trades", handler: (*Chaincode).get_receipt},
+	{Name: "get_trade_policy", Kind: "query", Args: []string{}, Doc: "read how perform_trade checks trades", Feature: "trades", handler: (*Chaincode).get_trade_policy},
+	{Name: "simulate", Kind: "query", Args: []string{"function", "*arg...*"}, Doc: "dry run an invoke and read what it would write", Feature: "core", handler: (*Chaincode).simulate},
+	{Name: "trade_history", Kind: "query", Args: []string{"kind", "key"}, Doc: "read the completed trades of a user or marble", Feature: "trades", handler: (*Chaincode).trade_history},
+}
+
+// ============================================================================================================================
+// New Chaincode - the marbles chaincode with just the functions of this preset
+// ============================================================================================================================
+func NewChaincode(preset Preset) *Chaincode {
+	t := &Chaincode{preset: preset, functions: make(map[string]Function)}
+	for _, fn := range registry{
+		if contains(preset.Features, fn.Feature) {
+			t.functions[fn.Name] = fn
+		}
+	}
+	return t
+}
+
+// ============================================================================================================================
+// Find Preset - look up a preset by name
+// ============================================================================================================================
+func FindPreset(name string) (Preset, bool) {
+	for _, preset := range Presets{
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return Preset{}, false
+}
+
+// ============================================================================================================================
+// Functions - the functions this chaincode has switched on, in registry order
+// ============================================================================================================================
+func (t *Chaincode) Functions() []Function {
+	var list []Function
+	for _, fn := range registry{
+		if _, ok := t.functions[fn.Name]; ok {
+			list = append(list, fn)
+		}
+	}
+	return list
+}
+
+// ============================================================================================================================
+// Lookup - find a switched on function by name
+// ============================================================================================================================
+func (t *Chaincode) Lookup(name string) (Function, bool) {
+	fn, ok := t.functions[name]
+	return fn, ok
+}
+
+// ============================================================================================================================
+// Invoke - Our entry point for invocations, what Run hands off to
+// ============================================================================================================================
+func (t *Chaincode) Invoke(stub Stub, function string, args []string) ([]byte, error) {
+	fmt.Println("run is running " + function)
+
+	fn, ok := t.functions[function]
+	if !ok || fn.Kind != "invoke" {
+		fmt.Println("run did not find func: " + function)					//error
+		return nil, errors.New("Received unknown function invocation")
+	}
+	counters := newStatsStub(stub)											//the counters are written once, at the end
+	if !fn.CleanAfter {
+		res, err := fn.handler(t, counters, args)
+		if err != nil {
+			return res, err
+		}
+		return res, counters.flush()
+	}
+	holdings := newHoldingsStub(counters)
+	res, err := fn.handler(t, holdings, args)
+	if err != nil {
+		return res, err														//its writes are thrown away, nothing to clean
+	}
+	owners, err := holdings.affected()
+	if err != nil {
+		owners = nil														//not sure who changed, look at every trade
+	}
+	err = cleanTrades(counters, owners)										//lets make sure their open trades are still valid
+	if err != nil {
+		return nil, err														//a half done clean must not be committed
+	}
+	return res, counters.flush()
+}
+
+// ============================================================================================================================
+// Read - Our entry point for queries, what Query hands off to
+// ============================================================================================================================
+func (t *Chaincode) Read(stub Stub, function string, args []string) ([]byte, error) {
+	fn, ok := t.functions[function]
+	if !ok || fn.Kind != "query" {
+		var names []string
+		for _, fn := range t.Functions(){
+			if fn.Kind == "query" {
+				names = append(names, "\"" + fn.Name + "\"")
+			}
+		}
+		expecting := names[len(names)-1]
+		if len(names) > 1 {
+			expecting = strings.Join(names[:len(names)-1], ", ") + " or " + expecting
+		}
+		return nil, errors.New("Invalid query function name. Expecting " + expecting)
+	}
+	return fn.handler(t, stub, args)
+}
+
+// ============================================================================================================================
+// Read - read a variable from chaincode state - (aka query)
+// ============================================================================================================================
+func (t *Chaincode) read(stub Stub, args []string) ([]byte, error) {
+	var name, jsonResp string
+	var err error
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the person to query")
+	}
+
+	name = args[0]
+	valAsbytes, err := stub.GetState(name)									//get the var from chaincode state
+	if err != nil {
+		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
+		return nil, errors.New(jsonResp)
+	}
+
+	return valAsbytes, nil													//send it onward
+}
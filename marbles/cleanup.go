@@ -0,0 +1,171 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+	"reflect"
+)
+
+// holdingsStub remembers what every key it writes held before the transaction, so cleanTrades can be
+// limited to the owners whose marbles changed
+type holdingsStub struct{
+	Stub
+	before map[string][]byte
+}
+
+func newHoldingsStub(stub Stub) *holdingsStub {
+	return &holdingsStub{Stub: stub, before: make(map[string][]byte)}
+}
+
+func (h *holdingsStub) PutState(key string, value []byte) error {
+	err := h.remember(key)
+	if err != nil {
+		return err
+	}
+	return h.Stub.PutState(key, value)
+}
+
+func (h *holdingsStub) DelState(key string) error {
+	err := h.remember(key)
+	if err != nil {
+		return err
+	}
+	return h.Stub.DelState(key)
+}
+
+func (h *holdingsStub) remember(key string) error {
+	if _, ok := h.before[key]; ok {
+		return nil																//only the first write knows the old value
+	}
+	valueAsBytes, err := h.Stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get state for " + key)
+	}
+	h.before[key] = append([]byte{}, valueAsBytes...)
+	return nil
+}
+
+func (h *holdingsStub) SetEvent(name string, payload []byte) error {
+	if eventStub, ok := h.Stub.(EventStub); ok {
+		return eventStub.SetEvent(name, payload)
+	}
+	return nil
+}
+
+func (h *holdingsStub) TxTimestamp() (int64, error) {
+	if timestampStub, ok := h.Stub.(TimestampStub); ok {
+		return timestampStub.TxTimestamp()
+	}
+	return 0, errors.New("Stub has no transaction timestamp")
+}
+
+// ============================================================================================================================
+// Affected - owners whose open trades may have lost an option, anyone whose marble was written or dropped from the index,
+// anyone whose token balance went down and the openers of trades the transaction added or changed
+// ============================================================================================================================
+func (h *holdingsStub) affected() (map[string]bool, error) {
+	owners := make(map[string]bool)
+	for key, valueAsBytes := range h.before{
+		switch key {
+		case marbleIndexStr:
+			var before []string
+			json.Unmarshal(valueAsBytes, &before)
+			after, err := getMarbleIndex(h.Stub)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range before{
+				if contains(after, name) {
+					continue
+				}
+				var marble Marble
+				if marbleAsBytes, ok := h.before[name]; ok {
+					json.Unmarshal(marbleAsBytes, &marble)
+				} else if marble, err = getMarble(h.Stub, name); err != nil {
+					continue
+				}
+				owners[normalizeUser(marble.User)] = true
+			}
+		case balancesStr:
+			var before map[string]int
+			json.Unmarshal(valueAsBytes, &before)
+			after, err := getBalances(h.Stub)
+			if err != nil {
+				return nil, err
+			}
+			for user, balance := range before{
+				if after[user] < balance {
+					owners[user] = true
+				}
+			}
+		case openTradesStr:
+			var before AllTrades
+			json.Unmarshal(valueAsBytes, &before)
+			after, err := getTrades(h.Stub)
+			if err != nil {
+				return nil, err
+			}
+			for _, trade := range after.OpenTrades{
+				same := false
+				for _, old := range before.OpenTrades{
+					if old.Timestamp == trade.Timestamp && reflect.DeepEqual(old, trade) {
+						same = true
+					}
+				}
+				if !same {
+					owners[normalizeUser(trade.User)] = true
+				}
+			}
+		default:
+			var marble Marble
+			if json.Unmarshal(valueAsBytes, &marble) == nil && marble.User != "" {
+				owners[normalizeUser(marble.User)] = true						//anything shaped like a marble counts
+			}
+		}
+	}
+	return owners, nil
+}
+
+// ============================================================================================================================
+// Clean Trades - admin re-checks every open trade, not just those of owners whose marbles changed
+// ============================================================================================================================
+func (t *Chaincode) clean_trades(stub Stub, args []string) ([]byte, error) {
+
+	//   0
+	// "admin"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start full clean trades")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	err := cleanTrades(stub, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end full clean trades")
+	return nil, nil
+}
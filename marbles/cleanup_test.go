@@ -0,0 +1,151 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// cleanupLedger has an open trade leaning on each thing a clean after function can take away, returns the trade IDs in order
+//   0 alice wants a blue 16 for her glass red 16 m1		1 alice wants a green 35 for her red 10 m2
+//   2 bob wants a red 16 for his blue 16 m3				3 bob offers 50 tokens for a green 35
+//   4 carol wants a red 16 for her green 35 m6
+func cleanupLedger(t *testing.T, cc *marbles.Chaincode) (*memstub.Stub, []string) {
+	stub := setup(t, cc)
+	for _, call := range [][]string{											//each call starts with its signer
+		{"alice", "init_marble", "m1", "red", "16", "alice", "", `{"material": "glass"}`},
+		{"alice", "init_marble", "m2", "red", "10", "alice"},
+		{"bob", "init_marble", "m3", "blue", "16", "bob"},
+		{"carol", "init_marble", "m6", "green", "35", "carol"},
+		{"alice", "open_trade", "alice", "blue", "16", "red", "16", `{"willing": [{"material": "glass"}]}`},
+		{"alice", "open_trade", "alice", "green", "35", "red", "10"},
+		{"bob", "open_trade", "bob", "red", "16", "blue", "16"},
+		{"bob", "open_trade", "bob", "green", "35", "50"},
+		{"carol", "open_trade", "carol", "red", "16", "green", "35"},
+	}{
+		mustInvoke(t, cc, stub, call[0], call[1:]...)
+	}
+	var ids []string
+	for _, trade := range openTrades(t, cc, stub){
+		ids = append(ids, strconv.FormatInt(trade.Timestamp, 10))
+	}
+	return stub, ids
+}
+
+// ============================================================================================================================
+// Test Clean After - whatever a clean after function takes away from an open trade, its own clean must find,
+// a full sweep right after may not change anything
+// ============================================================================================================================
+func TestCleanAfter(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	tests := map[string]func(stub *memstub.Stub, ids []string) []string{		//the call to check, signer first
+		"delete": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"admin", "delete", "admin", "m1"}
+		},
+		"set_user": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"alice", "set_user", "m1", "dave"}
+		},
+		"transfer_marbles": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"alice", "transfer_marbles", `[{"name": "m1", "user": "dave"}, {"name": "m2", "user": "dave"}]`}
+		},
+		"perform_trade": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"bob", "perform_trade", ids[0], "m3", "0"}						//bob gives up the m3 his own trade offers
+		},
+		"burn_marble": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"alice", "burn_marble", "m1", "alice"}
+		},
+		"merge_marbles": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"alice", "merge_marbles", "m1", "m2", "alice"}
+		},
+		"split_marble": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"alice", "split_marble", "m1", "alice", "a", "8", "b", "8"}
+		},
+		"update_attributes": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"alice", "update_attributes", "m1", "alice", `{"material": "wood"}`}
+		},
+		"transfer_tokens": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"bob", "transfer_tokens", "bob", "alice", "60"}				//bob can no longer pay 50
+		},
+		"approve_transfer": func(stub *memstub.Stub, ids []string) []string {
+			mustInvoke(t, cc, stub, "admin", "set_approval_rules", "admin", `{"rules": [{"min_size": 35, "approvers": ["dave"], "required": 1}]}`)
+			pending := string(mustInvoke(t, cc, stub, "carol", "set_user", "m6", "dave"))
+			return []string{"dave", "approve_transfer", pending, "dave"}
+		},
+		"import_state": func(stub *memstub.Stub, ids []string) []string {
+			return []string{"admin", "import_state", "admin", "merge", `{"version": 1, "open_trades": [{"user": "dave", "timestamp": 1, "want": {"color": "red", "size": 16}, "willing": [{"color": "purple", "size": 99}]}]}`}
+		},
+	}
+
+	for _, fn := range cc.Functions(){
+		if _, ok := tests[fn.Name]; fn.CleanAfter && !ok {
+			t.Errorf("%s cleans after itself but has no test here", fn.Name)
+		}
+	}
+	for name, call := range tests{
+		t.Run(name, func(t *testing.T) {
+			stub, ids := cleanupLedger(t, cc)
+			c := call(stub, ids)
+			mustInvoke(t, cc, stub, c[0], c[1:]...)
+			cleaned := stub.Snapshot()
+
+			mustInvoke(t, cc, stub, "admin", "clean_trades", "admin")
+			for _, write := range memstub.Diff(cleaned, stub.Snapshot()){
+				t.Errorf("the full sweep after %s changed %s", name, write.Key)
+			}
+			broken, err := marbles.CheckInvariants(stub, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range broken{
+				t.Error(msg)
+			}
+		})
+	}
+}
+
+// failingStub cannot read one key
+type failingStub struct{
+	*memstub.Stub
+	key string
+}
+
+func (f *failingStub) GetState(key string) ([]byte, error) {
+	if key == f.key {
+		return nil, errors.New("state unavailable")
+	}
+	return f.Stub.GetState(key)
+}
+
+func TestCleanAfterErrorsFailTheInvoke(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := tradingLedger(t, cc)
+	stub.Caller = "alice"
+	defer quiet()()
+	_, err := cc.Invoke(&failingStub{Stub: stub, key: "_balances"}, "set_user", []string{"m1", "dave"})	//only the clean reads balances
+	if err == nil || !strings.Contains(err.Error(), "balances") {
+		t.Fatalf("set_user with a clean that could not run: %v", err)
+	}
+}
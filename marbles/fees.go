@@ -0,0 +1,214 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+var feeScheduleStr = "_feeschedule"				//name for the key/value that will store the trade fee schedule
+var treasuryStr = "_treasury"					//name for the key/value that will store the fees collected so far
+var maxFeeAmount = 1000000						//largest amount a fee schedule may charge, per trade or per unit of size
+
+type FeeSchedule struct{
+	Mode string `json:"mode"`					//"flat", "size" or "none"
+	Amount int `json:"amount"`					//flat - tokens per trade, size - tokens per unit of marble size exchanged
+	Payer string `json:"payer"`					//"opener", "closer" or "both"
+}
+
+type Treasury struct{
+	Balance int `json:"balance"`				//fees collected and not yet spent
+	Collected map[string]int `json:"collected"`	//utc day (2006-01-02) -> fees collected that day
+}
+
+type FeeReport struct{
+	Balance int `json:"balance"`
+	Total int `json:"total"`
+	Periods map[string]int `json:"periods"`
+}
+
+// ============================================================================================================================
+// Get Fee Schedule - read the fee schedule, an empty schedule charges nothing
+// ============================================================================================================================
+func getFeeSchedule(stub Stub) (FeeSchedule, error) {
+	var schedule FeeSchedule
+	scheduleAsBytes, err := stub.GetState(feeScheduleStr)
+	if err != nil {
+		return schedule, errors.New("Failed to get fee schedule")
+	}
+	json.Unmarshal(scheduleAsBytes, &schedule)									//un stringify it aka JSON.parse()
+	return schedule, nil
+}
+
+// ============================================================================================================================
+// Get Treasury - read the fee treasury
+// ============================================================================================================================
+func getTreasury(stub Stub) (Treasury, error) {
+	var treasury Treasury
+	treasuryAsBytes, err := stub.GetState(treasuryStr)
+	if err != nil {
+		return treasury, errors.New("Failed to get treasury")
+	}
+	json.Unmarshal(treasuryAsBytes, &treasury)									//un stringify it aka JSON.parse()
+	if treasury.Collected == nil {
+		treasury.Collected = make(map[string]int)
+	}
+	return treasury, nil
+}
+
+// ============================================================================================================================
+// Trade Fee - what the fee schedule charges for a trade exchanging this much marble size, and who pays it
+// ============================================================================================================================
+func tradeFee(stub Stub, size int) (int, string, error) {
+	schedule, err := getFeeSchedule(stub)
+	if err != nil {
+		return 0, "", err
+	}
+
+	fee := 0
+	if schedule.Mode == "flat" {
+		fee = schedule.Amount
+	} else if schedule.Mode == "size" {
+		if size > 0 && schedule.Amount > int(^uint(0) >> 1) / size {
+			return 0, "", errors.New("Trade fee of " + strconv.Itoa(schedule.Amount) + " per unit of size " + strconv.Itoa(size) + " is too large")
+		}
+		fee = schedule.Amount * size
+	}
+	if fee <= 0 {
+		return 0, "", nil
+	}
+	return fee, schedule.Payer, nil
+}
+
+// ============================================================================================================================
+// Pay Trade Fee - debit a trade's fee from the paying side(s)
+// ============================================================================================================================
+func payTradeFee(balances map[string]int, payer string, opener string, closer string, fee int) error {
+	if fee <= 0 {
+		return nil
+	}
+
+	var err error
+	if payer == "closer" {
+		err = debitTokens(balances, closer, fee)
+	} else if payer == "both" {													//split it, opener covers the odd token
+		err = debitTokens(balances, opener, fee - fee / 2)
+		if err == nil {
+			err = debitTokens(balances, closer, fee / 2)
+		}
+	} else {
+		err = debitTokens(balances, opener, fee)
+	}
+	if err != nil {
+		return errors.New("Cannot pay trade fee of " + strconv.Itoa(fee) + ": " + err.Error())
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Collect Fee - add a fee to the treasury, bucketed by the day it was collected
+// ============================================================================================================================
+func collectFee(stub Stub, fee int) error {
+	treasury, err := getTreasury(stub)
+	if err != nil {
+		return err
+	}
+	day := time.Unix(0, makeTimestamp(stub) * int64(time.Millisecond)).UTC().Format("2006-01-02")
+	treasury.Balance += fee
+	treasury.Collected[day] += fee
+	jsonAsBytes, _ := json.Marshal(treasury)
+	return stub.PutState(treasuryStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Set Fee Schedule - admin sets how trades are charged
+// ============================================================================================================================
+func (t *Chaincode) set_fee_schedule(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0        1       2       3
+	// "admin", "flat", "2", "opener"
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+
+	fmt.Println("- start set fee schedule")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	schedule := FeeSchedule{}
+	schedule.Mode = strings.ToLower(args[1])
+	schedule.Payer = strings.ToLower(args[3])
+	if schedule.Mode != "flat" && schedule.Mode != "size" && schedule.Mode != "none" {
+		return nil, errors.New("2nd argument must be flat, size or none")
+	}
+	if schedule.Payer != "opener" && schedule.Payer != "closer" && schedule.Payer != "both" {
+		return nil, errors.New("4th argument must be opener, closer or both")
+	}
+	schedule.Amount, err = strconv.Atoi(args[2])
+	if err != nil || schedule.Amount < 0 || schedule.Amount > maxFeeAmount {
+		return nil, errors.New("3rd argument must be a numeric string between 0 and " + strconv.Itoa(maxFeeAmount))
+	}
+
+	jsonAsBytes, _ := json.Marshal(schedule)
+	err = stub.PutState(feeScheduleStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set fee schedule")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Fee Report - read the fees collected, per day or rolled up per month
+// ============================================================================================================================
+func (t *Chaincode) fee_report(stub Stub, args []string) ([]byte, error) {
+
+	//     0
+	// *"month"*
+	period := "day"
+	if len(args) > 0 {
+		period = strings.ToLower(args[0])
+	}
+	if period != "day" && period != "month" {
+		return nil, errors.New("1st argument must be day or month")
+	}
+
+	treasury, err := getTreasury(stub)
+	if err != nil {
+		return nil, err
+	}
+	report := FeeReport{Balance: treasury.Balance, Periods: make(map[string]int)}
+	for day, fee := range treasury.Collected{
+		if period == "month" {
+			day = day[:7]														//2006-01-02 -> 2006-01
+		}
+		report.Periods[day] += fee
+		report.Total += fee
+	}
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
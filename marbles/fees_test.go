@@ -0,0 +1,157 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// feeTrade has alice swap her red 16 for bob's blue 16, 32 units of size change hands
+func feeTrade(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, suffix string) string {
+	mustInvoke(t, cc, stub, "alice", "init_marble", "a" + suffix, "red", "16", "alice")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "b" + suffix, "blue", "16", "bob")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16")
+	trades := openTrades(t, cc, stub)
+	return strconv.FormatInt(trades[len(trades) - 1].Timestamp, 10)
+}
+
+func TestTradeFees(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, test := range []struct{
+		mode string
+		amount string
+		payer string
+		alice int
+		bob int
+	}{
+		{"none", "5", "opener", 100, 100},
+		{"flat", "3", "opener", 97, 100},
+		{"flat", "3", "closer", 100, 97},
+		{"flat", "3", "both", 98, 99},													//opener covers the odd token
+		{"size", "1", "opener", 68, 100},
+		{"size", "1", "closer", 100, 68},
+		{"size", "1", "both", 84, 84},
+		{"flat", "0", "both", 100, 100},
+	}{
+		name := test.mode + " " + test.amount + " " + test.payer
+		t.Run(name, func(t *testing.T) {
+			stub := setup(t, cc)
+			mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", test.mode, test.amount, test.payer)
+			id := feeTrade(t, cc, stub, "")
+			mustInvoke(t, cc, stub, "bob", "perform_trade", id, "b", "0")
+			checkBalance(t, cc, stub, "alice", test.alice)
+			checkBalance(t, cc, stub, "bob", test.bob)
+			report := feeReport(t, cc, stub, "day")
+			if fee := 200 - test.alice - test.bob; report.Balance != fee || report.Total != fee {
+				t.Fatalf("treasury %+v, want %d collected", report, fee)
+			}
+		})
+	}
+}
+
+func TestTradeFeeMustBeAffordable(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "101", "closer")
+	id := feeTrade(t, cc, stub, "")
+	mustFail(t, cc, stub, "bob", "perform_trade", id, "b", "0")						//bob has 100 tokens
+	checkOwner(t, stub, "a", "alice")
+	checkOwner(t, stub, "b", "bob")
+	checkBalance(t, cc, stub, "bob", 100)
+	if report := feeReport(t, cc, stub, "day"); report.Balance != 0 {
+		t.Fatalf("treasury took %d from a failed trade", report.Balance)
+	}
+
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "101", "both")	//51 and 50, both can pay
+	mustInvoke(t, cc, stub, "bob", "perform_trade", id, "b", "0")
+	checkBalance(t, cc, stub, "alice", 49)
+	checkBalance(t, cc, stub, "bob", 50)
+}
+
+func TestFeeScheduleLimits(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	for _, args := range [][]string{
+		{"flat", "-1", "opener"},
+		{"flat", "1000001", "opener"},
+		{"size", "99999999999999", "opener"},
+		{"flat", "2", "nobody"},
+		{"percent", "2", "opener"},
+	}{
+		mustFail(t, cc, stub, "admin", append([]string{"set_fee_schedule", "admin"}, args...)...)
+	}
+	mustFail(t, cc, stub, "bob", "set_fee_schedule", "bob", "flat", "1", "opener")		//admins only
+
+	huge := strconv.FormatInt(1 << 62 - 16, 10)										//with bob's 16, 4 tokens a unit wraps to a fee of 0
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "size", "4", "opener")
+	mustInvoke(t, cc, stub, "alice", "init_marble", "huge", "red", huge, "alice")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "b", "blue", "16", "bob")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", huge)
+	id := strconv.FormatInt(openTrades(t, cc, stub)[0].Timestamp, 10)
+	mustFail(t, cc, stub, "bob", "perform_trade", id, "b", "0")
+}
+
+func TestFeeReportPeriods(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	stub.Timestamp = 1464566400000 - 2000												//2016-05-30, apply moves it on a second per call
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "2", "opener")
+	for i, timestamp := range []int64{1464566400000, 1464566400000, 1464652800000, 1464739200000}{	//30 and 31 May, 1 June
+		if stub.Timestamp < timestamp {
+			stub.Timestamp = timestamp
+		}
+		suffix := strconv.Itoa(i)
+		id := feeTrade(t, cc, stub, suffix)
+		mustInvoke(t, cc, stub, "bob", "perform_trade", id, "b" + suffix, "0")
+	}
+
+	for _, test := range []struct{
+		period string
+		periods map[string]int
+	}{
+		{"day", map[string]int{"2016-05-30": 4, "2016-05-31": 2, "2016-06-01": 2}},
+		{"month", map[string]int{"2016-05": 6, "2016-06": 2}},
+	}{
+		report := feeReport(t, cc, stub, test.period)
+		if !reflect.DeepEqual(report.Periods, test.periods) || report.Total != 8 || report.Balance != 8 {
+			t.Errorf("fee report by %s: %+v, want %v", test.period, report, test.periods)
+		}
+	}
+	if _, err, _ := apply(cc, stub, "admin", "query", "fee_report", []string{"week"}); err == nil {
+		t.Error("fee report by week worked")
+	}
+}
+
+func feeReport(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, period string) marbles.FeeReport {
+	t.Helper()
+	res, err, _ := apply(cc, stub, "admin", "query", "fee_report", []string{period})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report marbles.FeeReport
+	json.Unmarshal(res, &report)
+	return report
+}
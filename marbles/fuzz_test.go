@@ -0,0 +1,399 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+var colors = []string{"red", "blue", "green"}
+var sizes = []string{"5", "16", "35"}
+
+// values that have tripped up argument parsing before, or might
+var oddArgs = []string{
+	"", " ", "0", "-1", "1", "16", "2147483648", "-9223372036854775809", "99999999999999999999", "1e3", "0x10", "abc",
+	"admin", "alice", "ALICE", "nope", "m1", "m0", "red", "asc", "desc", "replace", "merge", "user", "marble", "percent", "flat",
+	"{}", "[]", "null", "{", "[", "\"\"", "{\"page_size\": -5}", "{\"page_size\": 1000000}", "{\"bookmark\": \"!!\"}",
+	"{\"want\": {\"a\": \"b\"}, \"willing\": [{}, {}, {}]}", "[{\"name\": \"m1\", \"user\": \"bob\"}]", "{\"marbles\": null, \"trades\": null}",
+	"ümlaut", "\x00", strings.Repeat("x", 4096),
+}
+
+// call is one transaction of a run, kept so a failure can show how it got there
+type call struct{
+	Caller string
+	Function string
+	Args []string
+	Error string
+}
+
+// ============================================================================================================================
+// Fuzz Model - random marble creation, transfers, deletes and trading between a few users, for every preset,
+// the invariants are checked after each transaction. go test runs the seeds below, go test -fuzz FuzzModel finds more
+// ============================================================================================================================
+func FuzzModel(f *testing.F) {
+	for seed := int64(1); seed <= 20; seed++{
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		for _, preset := range marbles.Presets{
+			runModel(t, marbles.NewChaincode(preset), rand.New(rand.NewSource(seed)), 100)
+		}
+	})
+}
+
+func runModel(t *testing.T, cc *marbles.Chaincode, r *rand.Rand, steps int) {
+	stub := setup(t, cc)
+	cleaned := true																//no open trades yet
+	var calls []call
+
+	for step := 1; step <= steps; step++{
+		caller, function, args := nextCall(t, cc, stub, r)
+		owners, err := marbles.LiveMarbles(stub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		missed := checkIncremental(t, cc, stub, caller, function, args)
+
+		_, err, panicked := apply(cc, stub, caller, "invoke", function, args)
+		calls = append(calls, newCall(caller, function, args, err))
+		if panicked != nil {
+			fail(t, step, []string{fmt.Sprintf("panic: %v", panicked)}, calls)
+		}
+
+		if err == nil && function == "clean_trades" {
+			cleaned = true
+		} else if err == nil && function == "open_trade" {
+			cleaned = false														//its willing marbles are not checked until a full clean
+		}
+		broken, err := marbles.CheckInvariants(stub, cleaned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		broken = append(broken, missed...)
+		after, err := marbles.LiveMarbles(stub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		broken = append(broken, checkConserved(function, owners, after)...)
+		if len(broken) > 0 {
+			fail(t, step, broken, calls)
+		}
+	}
+}
+
+// ============================================================================================================================
+// Next Call - a transaction and who signs it, likely but not sure to succeed
+// ============================================================================================================================
+func nextCall(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, r *rand.Rand) (string, string, []string) {
+	owners, err := marbles.LiveMarbles(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"nope"}													//sometimes a marble that is not there
+	for name := range owners{
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	trades := openTrades(t, cc, stub)
+	signer := func(user string) string {
+		if r.Intn(20) == 0 {
+			return pick(r, users)											//sometimes the wrong user
+		}
+		return user
+	}
+
+	switch n := r.Intn(100); {
+	case n < 25:
+		user := pick(r, users)
+		return signer(user), "init_marble", []string{"m" + strconv.Itoa(r.Intn(40)), pick(r, colors), pick(r, sizes), user}
+	case n < 40:
+		name := pick(r, names)
+		return signer(owners[name]), "set_user", []string{name, pick(r, users)}
+	case n < 45:
+		return signer("admin"), "delete", []string{"admin", pick(r, names)}
+	case n < 48:
+		return signer("admin"), "clean_trades", []string{"admin"}
+	case n < 52:
+		from := pick(r, users)
+		return signer(from), "transfer_tokens", []string{from, pick(r, users), strconv.Itoa(1 + r.Intn(100))}
+	case n < 65:
+		user := pick(r, users)
+		args := []string{user, pick(r, colors), pick(r, sizes)}
+		for i := r.Intn(3); i > 0; i--{
+			args = append(args, pick(r, colors), pick(r, sizes))
+		}
+		if len(args) == 3 || r.Intn(4) == 0 {
+			args = append(args, strconv.Itoa(1 + r.Intn(30)))					//a price
+		}
+		return signer(user), "open_trade", args
+	}
+
+	if len(trades) == 0 {
+		user := pick(r, users)
+		return signer(user), "init_marble", []string{"m" + strconv.Itoa(r.Intn(40)), pick(r, colors), pick(r, sizes), user}
+	}
+	trade := trades[r.Intn(len(trades))]
+	id := strconv.FormatInt(trade.Timestamp, 10)
+	if r.Intn(100) < 20 {
+		return signer(trade.User), "remove_trade", []string{id}
+	}
+
+	closerMarble := pick(r, names)
+	for _, name := range names{													//prefer someone else's marble
+		if owner, ok := owners[name]; ok && owner != trade.User && r.Intn(2) == 0 {
+			closerMarble = name
+		}
+	}
+	index := r.Intn(len(trade.Willing) + 1) - 1									//-1 sometimes, it is only right for tokens only trades
+	if len(trade.Willing) == 0 {
+		index = -1
+	}
+	return signer(owners[closerMarble]), "perform_trade", []string{id, closerMarble, strconv.Itoa(index)}
+}
+
+// ============================================================================================================================
+// Open Trades - every open trade, read through list_trades like a client would
+// ============================================================================================================================
+func openTrades(t testing.TB, cc *marbles.Chaincode, stub *memstub.Stub) []marbles.AnOpenTrade {
+	if _, ok := cc.Lookup("list_trades"); !ok {
+		return nil
+	}
+	var trades []marbles.AnOpenTrade
+	bookmark := ""
+	for {
+		paramsAsBytes, _ := json.Marshal(marbles.TradeListParams{ListParams: marbles.ListParams{PageSize: 50, Bookmark: bookmark}})
+		res, err, _ := apply(cc, stub, "", "query", "list_trades", []string{string(paramsAsBytes)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page marbles.TradePage
+		json.Unmarshal(res, &page)
+		trades = append(trades, page.Trades...)
+		if page.Bookmark == "" {
+			return trades
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// ============================================================================================================================
+// Check Conserved - trades and transfers only move marbles, a trade moves at most one each way
+// ============================================================================================================================
+func checkConserved(function string, before map[string]string, after map[string]string) []string {
+	if function != "set_user" && function != "open_trade" && function != "remove_trade" && function != "perform_trade" && function != "clean_trades" {
+		return nil
+	}
+	var broken []string
+	moved := 0
+	for name, owner := range before{
+		newOwner, ok := after[name]
+		if !ok {
+			broken = append(broken, function + " lost marble " + name)
+		} else if newOwner != owner {
+			moved++
+		}
+	}
+	for name := range after{
+		if _, ok := before[name]; !ok {
+			broken = append(broken, function + " made marble " + name)
+		}
+	}
+	limit := 0
+	if function == "set_user" {
+		limit = 1
+	} else if function == "perform_trade" {
+		limit = 2
+	}
+	if moved > limit {
+		broken = append(broken, function + " moved " + strconv.Itoa(moved) + " marbles")
+	}
+	return broken
+}
+
+// ============================================================================================================================
+// Check Incremental - from a fully cleaned ledger, the clean after this transaction must leave nothing for a full sweep,
+// the ledger is put back as it was
+// ============================================================================================================================
+func checkIncremental(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, caller string, function string, args []string) []string {
+	fn, ok := cc.Lookup(function)
+	if _, full := cc.Lookup("clean_trades"); !ok || !fn.CleanAfter || !full {
+		return nil
+	}
+	before := stub.Snapshot()
+	timestamp := stub.Timestamp
+	defer func(){
+		stub.Restore(before)
+		stub.Timestamp = timestamp
+	}()
+
+	mustInvoke(t, cc, stub, "admin", "clean_trades", "admin")
+	_, err, panicked := apply(cc, stub, caller, "invoke", function, args)
+	if err != nil || panicked != nil {
+		return nil																//the real step reports it
+	}
+	cleaned := stub.Snapshot()
+	mustInvoke(t, cc, stub, "admin", "clean_trades", "admin")
+	var broken []string
+	for _, write := range memstub.Diff(cleaned, stub.Snapshot()){
+		broken = append(broken, "incremental clean after " + function + " left " + write.Key + " for the full sweep")
+	}
+	return broken
+}
+
+// ============================================================================================================================
+// Fuzz Args - any function with any args on a ledger with some marbles and trades, nothing may panic or break an invariant
+// ============================================================================================================================
+func FuzzArgs(f *testing.F) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	functions := fuzzableFunctions(cc)
+	for i := range functions{
+		for _, arg := range oddArgs{
+			f.Add(uint8(i), arg, "m1", "alice", "16", uint8(len(functions[i].Args)), "alice")
+		}
+	}
+	f.Fuzz(func(t *testing.T, function uint8, a string, b string, c string, d string, count uint8, caller string) {
+		fn := functions[int(function) % len(functions)]
+		args := []string{a, b, c, d, a + b}[:int(count) % 6]
+		stub := tradingLedger(t, cc)
+
+		_, err, panicked := apply(cc, stub, caller, fn.Kind, fn.Name, args)
+		calls := []call{newCall(caller, fn.Name, args, err)}
+		if panicked != nil {
+			fail(t, 1, []string{fmt.Sprintf("panic: %v", panicked)}, calls)
+		}
+		broken, err := marbles.CheckInvariants(stub, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(broken) > 0 {
+			fail(t, 1, broken, calls)
+		}
+	})
+}
+
+// ============================================================================================================================
+// Test Random Args - many random calls in a row from the odd args, so one call can trip over what another left behind
+// ============================================================================================================================
+func TestRandomArgs(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	functions := fuzzableFunctions(cc)
+	runs := 50
+	if testing.Short() {
+		runs = 5
+	}
+	for seed := int64(1); seed <= int64(runs); seed++{
+		r := rand.New(rand.NewSource(seed))
+		stub := tradingLedger(t, cc)
+		pool := append([]string{}, oddArgs...)
+		pool = append(pool, strconv.FormatInt(stub.Timestamp - 1000, 10), strconv.FormatInt(stub.Timestamp, 10))	//the trade IDs
+
+		var calls []call
+		for step := 1; step <= 100; step++{
+			fn := functions[r.Intn(len(functions))]
+			args := make([]string, r.Intn(len(fn.Args) + 3))
+			for i := range args{
+				args[i] = pick(r, pool)
+			}
+			caller := pick(r, append([]string{"admin", ""}, users...))
+			_, err, panicked := apply(cc, stub, caller, fn.Kind, fn.Name, args)
+			calls = append(calls, newCall(caller, fn.Name, args, err))
+			if panicked != nil {
+				fail(t, step, []string{fmt.Sprintf("seed %d panic: %v", seed, panicked)}, calls)
+			}
+			broken, err := marbles.CheckInvariants(stub, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(broken) > 0 {
+				fail(t, step, append([]string{"seed " + strconv.FormatInt(seed, 10)}, broken...), calls)
+			}
+		}
+	}
+}
+
+// fuzzableFunctions is every function but write, a raw write can put anything anywhere
+func fuzzableFunctions(cc *marbles.Chaincode) []marbles.Function {
+	var functions []marbles.Function
+	for _, fn := range cc.Functions(){
+		if fn.Name != "write" {
+			functions = append(functions, fn)
+		}
+	}
+	return functions
+}
+
+// tradingLedger is a fresh ledger with a few marbles and two open trades
+func tradingLedger(t *testing.T, cc *marbles.Chaincode) *memstub.Stub {
+	stub := setup(t, cc)
+	for _, call := range [][]string{											//each call starts with its signer
+		{"alice", "init_marble", "m1", "red", "16", "alice"}, {"bob", "init_marble", "m2", "blue", "16", "bob"}, {"carol", "init_marble", "m3", "green", "35", "carol"},
+		{"alice", "open_trade", "alice", "blue", "16", "red", "16"}, {"bob", "open_trade", "bob", "green", "35", "10"},
+	}{
+		mustInvoke(t, cc, stub, call[0], call[1:]...)
+	}
+	return stub
+}
+
+func newCall(caller string, function string, args []string, err error) call {
+	c := call{Caller: caller, Function: function, Args: args}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// fail reports what broke and every call that led to it
+func fail(t *testing.T, step int, broken []string, calls []call) {
+	t.Helper()
+	lines := []string{"step " + strconv.Itoa(step) + " broke:"}
+	for _, msg := range broken{
+		lines = append(lines, "  " + msg)
+	}
+	lines = append(lines, "after:")
+	for i, c := range calls{
+		args := make([]string, len(c.Args))
+		for i := range c.Args{
+			args[i] = c.Args[i]
+			if len(args[i]) > 40 {
+				args[i] = args[i][:40] + "...(" + strconv.Itoa(len(c.Args[i])) + " bytes)"
+			}
+		}
+		argsAsBytes, _ := json.Marshal(args)
+		line := fmt.Sprintf("  %3d %s %s as %q", i + 1, c.Function, argsAsBytes, c.Caller)
+		if c.Error != "" {
+			line += " -> " + c.Error
+		}
+		lines = append(lines, line)
+	}
+	t.Fatal(strings.Join(lines, "\n"))
+}
+
+func pick(r *rand.Rand, list []string) string {
+	return list[r.Intn(len(list))]
+}
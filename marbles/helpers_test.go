@@ -0,0 +1,101 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+var devnull, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+
+var users = []string{"alice", "bob", "carol", "dave"}
+
+// ============================================================================================================================
+// Setup - a fresh ledger with an admin, the users and some tokens to trade with
+// ============================================================================================================================
+func setup(tb testing.TB, cc *marbles.Chaincode) *memstub.Stub {
+	stub := memstub.New()
+	stub.Timestamp = 1464000000000												//runs only repeat if time does
+	mustInvoke(tb, cc, stub, "admin", "init", "1", "admin")
+	for _, user := range users{
+		mustInvoke(tb, cc, stub, user, "register_user", user, user, "co")
+		if _, ok := cc.Lookup("mint_tokens"); ok {
+			mustInvoke(tb, cc, stub, "admin", "mint_tokens", "admin", user, "100")
+		}
+	}
+	return stub
+}
+
+// ============================================================================================================================
+// Must Invoke - run an invoke signed by caller that has to work
+// ============================================================================================================================
+func mustInvoke(tb testing.TB, cc *marbles.Chaincode, stub *memstub.Stub, caller string, call ...string) []byte {
+	tb.Helper()
+	res, err, panicked := apply(cc, stub, caller, "invoke", call[0], call[1:])
+	if err != nil || panicked != nil {
+		tb.Fatalf("%s as %s: %v %v", strings.Join(call, " "), caller, err, panicked)
+	}
+	return res
+}
+
+// ============================================================================================================================
+// Must Fail - run an invoke signed by caller that has to be refused
+// ============================================================================================================================
+func mustFail(tb testing.TB, cc *marbles.Chaincode, stub *memstub.Stub, caller string, call ...string) {
+	tb.Helper()
+	_, err, panicked := apply(cc, stub, caller, "invoke", call[0], call[1:])
+	if err == nil || panicked != nil {
+		tb.Fatalf("%s as %s: worked %v", strings.Join(call, " "), caller, panicked)
+	}
+}
+
+// ============================================================================================================================
+// Apply - run one transaction signed by caller a second after the last, a failed invoke is rolled back like the peer would,
+// a panic is an error of its own. The chaincode's logging goes nowhere.
+// ============================================================================================================================
+func apply(cc *marbles.Chaincode, stub *memstub.Stub, caller string, kind string, function string, args []string) (res []byte, err error, panicked interface{}) {
+	stub.Timestamp += 1000
+	stub.Caller = caller
+	stub.ClearEvents()
+	before := stub.Snapshot()
+	stdout := os.Stdout
+	os.Stdout = devnull
+	defer func(){
+		os.Stdout = stdout
+		if p := recover(); p != nil {
+			stub.Restore(before)
+			panicked = p
+		}
+	}()
+	if kind == "query" {
+		res, err = cc.Read(stub, function, args)
+	} else {
+		res, err = cc.Invoke(stub, function, args)
+	}
+	if err != nil && kind != "query" {
+		stub.Restore(before)
+	}
+	return res, err, nil
+}
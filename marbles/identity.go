@@ -0,0 +1,68 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// ============================================================================================================================
+// Get Caller - the user who signed the transaction, never a name out of the args
+// ============================================================================================================================
+func getCaller(stub Stub) (string, error) {
+	caller, err := stub.GetCaller()
+	if err != nil || len(normalizeUser(caller)) <= 0 {
+		return "", errors.New("Could not identify the caller")
+	}
+	return normalizeUser(caller), nil
+}
+
+// ============================================================================================================================
+// Check Signer - errors unless this user signed the transaction
+// ============================================================================================================================
+func checkSigner(stub Stub, user string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if caller != normalizeUser(user) {
+		return errors.New("Transaction is signed by " + caller + ", not " + user)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Caller From Certificate - the user a peer's transaction certificate was issued to, its subject common name
+// ============================================================================================================================
+func CallerFromCertificate(cert []byte) (string, error) {
+	if block, _ := pem.Decode(cert); block != nil {
+		cert = block.Bytes
+	}
+	parsed, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return "", errors.New("Failed to parse the caller's certificate")
+	}
+	if len(normalizeUser(parsed.Subject.CommonName)) <= 0 {
+		return "", errors.New("Caller's certificate names no user")
+	}
+	return normalizeUser(parsed.Subject.CommonName), nil
+}
@@ -0,0 +1,120 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"strconv"
+	"encoding/json"
+)
+
+// ============================================================================================================================
+// Check Invariants - what must hold of the ledger between transactions, one message per broken rule
+// open_trade does not check the willing marbles, only once cleanTrades has run must every option be satisfiable
+// and every price covered by the opener's balance
+// ============================================================================================================================
+func CheckInvariants(stub Stub, cleaned bool) ([]string, error) {
+	var broken []string
+
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, name := range marbleIndex{
+		if seen[name] {
+			broken = append(broken, "marble " + name + " is in the index twice")
+		}
+		seen[name] = true
+		marble, err := getMarble(stub, name)
+		if err != nil {
+			broken = append(broken, "indexed marble " + name + " does not exist")
+		} else if marble.Name != name {
+			broken = append(broken, "indexed marble " + name + " is stored as " + marble.Name)
+		} else if marble.Status != "" {
+			broken = append(broken, "indexed marble " + name + " is " + marble.Status)
+		}
+	}
+
+	trades, err := getTrades(stub)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := getBalances(stub)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int64]bool)
+	for _, trade := range trades.OpenTrades{
+		id := strconv.FormatInt(trade.Timestamp, 10)
+		if ids[trade.Timestamp] {
+			broken = append(broken, "open trade " + id + " is not unique")
+		}
+		ids[trade.Timestamp] = true
+		if !cleaned {
+			continue
+		}
+		if len(trade.Willing) == 0 && trade.Price == 0 {
+			broken = append(broken, "open trade " + id + " has no options left")
+		}
+		if trade.Price > balances[normalizeUser(trade.User)] {
+			broken = append(broken, "open trade " + id + " price " + strconv.Itoa(trade.Price) + " is more than " + trade.User + " has")
+		}
+		for x, option := range trade.Willing{
+			_, err := findMarble4Trade(stub, trade.User, option)
+			if err != nil {
+				broken = append(broken, "open trade " + id + " option " + strconv.Itoa(x) + " cannot be satisfied by " + trade.User)
+			}
+		}
+	}
+
+	stats, err := getStats(stub)
+	if err != nil {
+		return nil, err
+	}
+	recount, err := recountStats(stub)
+	if err != nil {
+		return nil, err
+	}
+	statsAsBytes, _ := json.Marshal(stats)
+	recountAsBytes, _ := json.Marshal(recount)
+	if string(statsAsBytes) != string(recountAsBytes) {
+		broken = append(broken, "stats " + string(statsAsBytes) + " do not match a recount " + string(recountAsBytes))
+	}
+	return broken, nil
+}
+
+// ============================================================================================================================
+// Live Marbles - owner of every indexed marble that has not been tombstoned, by name
+// ============================================================================================================================
+func LiveMarbles(stub Stub) (map[string]string, error) {
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string)
+	for _, name := range marbleIndex{
+		marble, err := getMarble(stub, name)
+		if err != nil || marble.Status != "" {
+			continue
+		}
+		owners[name] = normalizeUser(marble.User)
+	}
+	return owners, nil
+}
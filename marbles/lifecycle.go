@@ -0,0 +1,443 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"strings"
+)
+
+var historyPrefix = "_history_"					//prefix for the key/value that will store the history of one marble
+
+type MarbleEvent struct{
+	Action string `json:"action"`				//what happened, "burned", "merged", "split"...
+	User string `json:"user"`					//who did it
+	Timestamp int64 `json:"timestamp"`			//utc timestamp of when it happened
+	Detail string `json:"detail"`				//human readable extra info
+}
+
+type MarbleHistory struct{
+	Events []MarbleEvent `json:"events"`
+}
+
+// ============================================================================================================================
+// Get Marble - read a marble, errors if it does not exist
+// ============================================================================================================================
+func getMarble(stub Stub, name string) (Marble, error) {
+	var res Marble
+	marbleAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return res, errors.New("Failed to get marble " + name)
+	}
+	if len(marbleAsBytes) == 0 {
+		return res, errors.New("Marble " + name + " does not exist")
+	}
+	json.Unmarshal(marbleAsBytes, &res)											//un stringify it aka JSON.parse()
+	return res, nil
+}
+
+// ============================================================================================================================
+// Get Owned Marble - read a marble that has not been tombstoned and is owned by this user,
+// the transaction must be signed by them or their operator
+// ============================================================================================================================
+func getOwnedMarble(stub Stub, name string, user string) (Marble, error) {
+	res, err := getMarble(stub, name)
+	if err != nil {
+		return res, err
+	}
+	if res.Status != "" {
+		return res, errors.New("Marble " + name + " is " + res.Status)
+	}
+	if normalizeUser(res.User) != normalizeUser(user) {
+		return res, errors.New("Marble " + name + " is not owned by " + user)
+	}
+	return res, checkCaller(stub, res.User, res.Name)
+}
+
+// ============================================================================================================================
+// Put Marble - write a marble with its name as key
+// ============================================================================================================================
+func putMarble(stub Stub, marble Marble) error {
+	oldAsBytes, err := stub.GetState(marble.Name)
+	if err != nil {
+		return errors.New("Failed to get marble " + marble.Name)
+	}
+	old := Marble{}
+	json.Unmarshal(oldAsBytes, &old)											//un stringify it aka JSON.parse()
+	marble.Version = schemaVersion
+	jsonAsBytes, _ := json.Marshal(marble)
+	err = stub.PutState(marble.Name, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	return updateMarbleStats(stub, old, marble)
+}
+
+// ============================================================================================================================
+// Delete Marble - remove a marble's key, nothing happens if it is not a marble
+// ============================================================================================================================
+func deleteMarble(stub Stub, name string) error {
+	oldAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return errors.New("Failed to get marble " + name)
+	}
+	old := Marble{}
+	json.Unmarshal(oldAsBytes, &old)											//un stringify it aka JSON.parse()
+	err = stub.DelState(name)
+	if err != nil {
+		return errors.New("Failed to delete state")
+	}
+	if old.Name != name {
+		return nil
+	}
+	return updateMarbleStats(stub, old, Marble{})
+}
+
+// ============================================================================================================================
+// Get Trades - read the open trades
+// ============================================================================================================================
+func getTrades(stub Stub) (AllTrades, error) {
+	var trades AllTrades
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return trades, errors.New("Failed to get opentrades")
+	}
+	json.Unmarshal(tradesAsBytes, &trades)										//un stringify it aka JSON.parse()
+	return trades, nil
+}
+
+// ============================================================================================================================
+// Put Trades - rewrite the open trades, keeping the trade counters in step
+// ============================================================================================================================
+func putTrades(stub Stub, trades AllTrades) error {
+	old, err := getTrades(stub)
+	if err != nil {
+		return err
+	}
+	trades.Version = schemaVersion
+	jsonAsBytes, _ := json.Marshal(trades)
+	err = stub.PutState(openTradesStr, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	return updateTradeStats(stub, old, trades)
+}
+
+// ============================================================================================================================
+// Get Marble Index - read the list of all known marble names
+// ============================================================================================================================
+func getMarbleIndex(stub Stub) ([]string, error) {
+	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get marble index")
+	}
+	var marbleIndex []string
+	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
+	return marbleIndex, nil
+}
+
+// ============================================================================================================================
+// Put Marble Index - rewrite the list of all known marble names
+// ============================================================================================================================
+func putMarbleIndex(stub Stub, marbleIndex []string) error {
+	jsonAsBytes, _ := json.Marshal(marbleIndex)
+	return stub.PutState(marbleIndexStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Remove From Index - drop a name from the marble index
+// ============================================================================================================================
+func removeFromIndex(marbleIndex []string, name string) []string {
+	for i := range marbleIndex{
+		if marbleIndex[i] == name{
+			return append(marbleIndex[:i], marbleIndex[i+1:]...)
+		}
+	}
+	return marbleIndex
+}
+
+// ============================================================================================================================
+// Record History - append an event to a marble's history
+// ============================================================================================================================
+func recordHistory(stub Stub, name string, action string, user string, detail string) error {
+	historyAsBytes, err := stub.GetState(historyPrefix + name)
+	if err != nil {
+		return errors.New("Failed to get history for " + name)
+	}
+	var history MarbleHistory
+	json.Unmarshal(historyAsBytes, &history)									//un stringify it aka JSON.parse()
+	event := MarbleEvent{Action: action, User: normalizeUser(user), Timestamp: makeTimestamp(stub), Detail: detail}
+	history.Events = append(history.Events, event)
+	jsonAsBytes, _ := json.Marshal(history)
+	err = stub.PutState(historyPrefix + name, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	setEvent(stub, "marble_" + action, HistoryEvent{Name: name, MarbleEvent: event})
+	return nil
+}
+
+// ============================================================================================================================
+// Tombstone Marble - mark a marble as gone, it keeps its key so the name is never reused
+// ============================================================================================================================
+func tombstoneMarble(stub Stub, marble Marble, status string) error {
+	marble.Status = status
+	return putMarble(stub, marble)
+}
+
+// ============================================================================================================================
+// Burn Marble - owner destroys a marble
+// ============================================================================================================================
+func (t *Chaincode) burn_marble(stub Stub, args []string) ([]byte, error) {
+
+	//   0       1
+	// "name", "bob"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start burn marble")
+	marble, err := getOwnedMarble(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tombstoneMarble(stub, marble, "burned")
+	if err != nil {
+		return nil, err
+	}
+	err = putMarbleIndex(stub, removeFromIndex(marbleIndex, marble.Name))
+	if err != nil {
+		return nil, err
+	}
+	err = recordHistory(stub, marble.Name, "burned", args[1], "")
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end burn marble")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Merge Marbles - combine two marbles of the same color and owner, the 1st one absorbs the 2nd
+// ============================================================================================================================
+func (t *Chaincode) merge_marbles(stub Stub, args []string) ([]byte, error) {
+
+	//   0        1        2
+	// "name1", "name2", "bob"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start merge marbles")
+	if args[0] == args[1] {
+		return nil, errors.New("Cannot merge a marble with itself")
+	}
+	keep, err := getOwnedMarble(stub, args[0], args[2])
+	if err != nil {
+		return nil, err
+	}
+	gone, err := getOwnedMarble(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(keep.Color) != strings.ToLower(gone.Color) {
+		return nil, errors.New("Cannot merge a " + keep.Color + " marble with a " + gone.Color + " marble")
+	}
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSizePolicy(policy, keep.Size + gone.Size)						//one fewer marble, only the size can break the policy
+	if err != nil {
+		return nil, err
+	}
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keep.Size += gone.Size
+	err = putMarble(stub, keep)
+	if err != nil {
+		return nil, err
+	}
+	err = tombstoneMarble(stub, gone, "merged")
+	if err != nil {
+		return nil, err
+	}
+	err = putMarbleIndex(stub, removeFromIndex(marbleIndex, gone.Name))
+	if err != nil {
+		return nil, err
+	}
+	err = recordHistory(stub, keep.Name, "merged", args[2], "absorbed " + gone.Name + ", size now " + strconv.Itoa(keep.Size))
+	if err != nil {
+		return nil, err
+	}
+	err = recordHistory(stub, gone.Name, "merged", args[2], "merged into " + keep.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end merge marbles")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Split Marble - break a marble into several new marbles whose sizes add up to the original
+// ============================================================================================================================
+func (t *Chaincode) split_marble(stub Stub, args []string) ([]byte, error) {
+
+	//   0       1       2       3       4       5
+	// "name", "bob", "name1", "20", "name2", "15" *...*
+	if len(args) < 6 || len(args)%2 != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name, user and at least 2 name/size pairs")
+	}
+
+	fmt.Println("- start split marble")
+	marble, err := getOwnedMarble(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := getAttributeSchema(stub)
+	if err != nil {
+		return nil, err
+	}
+	color := strings.ToLower(marble.Color)
+	user := normalizeUser(marble.User)
+	supply.Total--																//the pieces replace the marble
+	supply.Colors[color]--
+	supply.Users[user]--
+
+	var pieces []Marble
+	total := 0
+	for i:=2; i < len(args); i+=2 {												//validate every piece before writing any
+		if len(args[i]) <= 0 || strings.HasPrefix(args[i], "_") {
+			return nil, errors.New("New marble names must be non-empty and not start with _")
+		}
+		existing, err := stub.GetState(args[i])
+		if err != nil {
+			return nil, errors.New("Failed to get marble " + args[i])
+		}
+		if len(existing) > 0 {
+			return nil, errors.New("Marble " + args[i] + " already exists")
+		}
+		for x := range pieces{
+			if pieces[x].Name == args[i] {
+				return nil, errors.New("Marble " + args[i] + " is listed twice")
+			}
+		}
+		size, err := strconv.Atoi(args[i + 1])
+		if err != nil || size <= 0 {
+			return nil, errors.New("is not a positive numeric string " + args[i + 1])
+		}
+		err = checkSupplyPolicy(policy, supply, color, size, user)				//each piece counts like a newly minted marble
+		if err != nil {
+			return nil, err
+		}
+		supply.Total++
+		supply.Colors[color]++
+		supply.Users[user]++
+		total += size
+		piece := Marble{Name: args[i], Color: marble.Color, Size: size, User: marble.User}
+		for key, value := range marble.Attributes{								//pieces keep what the marble was made of
+			if piece.Attributes == nil {
+				piece.Attributes = make(map[string]string)
+			}
+			piece.Attributes[key] = value
+		}
+		err = validateAttributes(schema, piece.Attributes)						//the schema may have changed since it was minted
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, piece)
+	}
+	if total != marble.Size {
+		return nil, errors.New("Sizes add up to " + strconv.Itoa(total) + " but the marble is size " + strconv.Itoa(marble.Size))
+	}
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tombstoneMarble(stub, marble, "split")
+	if err != nil {
+		return nil, err
+	}
+	marbleIndex = removeFromIndex(marbleIndex, marble.Name)
+	var names []string
+	for i := range pieces{
+		err = putMarble(stub, pieces[i])
+		if err != nil {
+			return nil, err
+		}
+		err = recordHistory(stub, pieces[i].Name, "split", args[1], "split from " + marble.Name)
+		if err != nil {
+			return nil, err
+		}
+		marbleIndex = append(marbleIndex, pieces[i].Name)
+		names = append(names, pieces[i].Name)
+	}
+	err = putMarbleIndex(stub, marbleIndex)
+	if err != nil {
+		return nil, err
+	}
+	err = recordHistory(stub, marble.Name, "split", args[1], "split into " + strings.Join(names, ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end split marble")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Marble History - read the history of one marble
+// ============================================================================================================================
+func (t *Chaincode) marble_history(stub Stub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the marble to query")
+	}
+
+	historyAsBytes, err := stub.GetState(historyPrefix + args[0])
+	if err != nil {
+		return nil, errors.New("Failed to get history for " + args[0])
+	}
+	var history MarbleHistory
+	json.Unmarshal(historyAsBytes, &history)									//un stringify it aka JSON.parse()
+	jsonAsBytes, _ := json.Marshal(history)
+	return jsonAsBytes, nil
+}
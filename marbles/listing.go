@@ -0,0 +1,348 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"strconv"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+var defaultPageSize = 25						//page size when none is asked for
+var maxPageSize = 100							//biggest page a single query may return
+
+type ListParams struct{
+	PageSize int `json:"page_size"`
+	Bookmark string `json:"bookmark"`			//from the previous page, empty for the first page
+	Sort string `json:"sort"`					//"name", "size", "color", "owner" or "timestamp"
+	Order string `json:"order"`				//"asc" or "desc"
+}
+
+type MarbleListParams struct{
+	ListParams
+	Filter MarbleQuery `json:"filter"`
+}
+
+type TradeQuery struct{
+	User string `json:"user"`					//empty matches any opener
+	Color string `json:"color"`				//wanted color, empty matches any color
+	Size int `json:"size"`						//wanted size, 0 matches any size
+	Attributes map[string]string `json:"attributes"`	//every listed attribute must be wanted
+}
+
+type TradeListParams struct{
+	ListParams
+	Filter TradeQuery `json:"filter"`
+}
+
+type sortKey struct{
+	Str string `json:"s,omitempty"`
+	Num int64 `json:"n,omitempty"`
+	ID string `json:"id"`						//tiebreaker, marble name or trade timestamp
+}
+
+type bookmark struct{
+	Sort string `json:"sort"`
+	Order string `json:"order"`
+	Last sortKey `json:"last"`				//key of the last item on the previous page
+}
+
+type MarblePage struct{
+	Marbles []Marble `json:"marbles"`
+	Bookmark string `json:"bookmark"`			//pass back for the next page, empty on the last page
+	Count int `json:"count"`					//marbles matching the filter across all pages
+}
+
+type TradePage struct{
+	Trades []AnOpenTrade `json:"trades"`
+	Bookmark string `json:"bookmark"`
+	Count int `json:"count"`
+}
+
+type keyedItem struct{
+	key sortKey
+	pos int										//position in the unsorted list
+}
+
+type byKey struct{
+	items []keyedItem
+	desc bool
+}
+
+func (s byKey) Len() int { return len(s.items) }
+func (s byKey) Swap(i, j int) { s.items[i], s.items[j] = s.items[j], s.items[i] }
+func (s byKey) Less(i, j int) bool {
+	if s.desc {
+		return compareKeys(s.items[i].key, s.items[j].key) > 0
+	}
+	return compareKeys(s.items[i].key, s.items[j].key) < 0
+}
+
+// ============================================================================================================================
+// Compare Keys - order two sort keys, only one of Str and Num is set for any one sort
+// ============================================================================================================================
+func compareKeys(a sortKey, b sortKey) int {
+	if a.Num != b.Num {
+		if a.Num < b.Num {
+			return -1
+		}
+		return 1
+	}
+	if a.Str != b.Str {
+		return strings.Compare(a.Str, b.Str)
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// ============================================================================================================================
+// Check List Params - fill in defaults and errors if the page size, order or bookmark make no sense
+// ============================================================================================================================
+func checkListParams(params *ListParams, defaultSort string) (*bookmark, error) {
+	if params.PageSize == 0 {
+		params.PageSize = defaultPageSize
+	}
+	if params.PageSize < 0 || params.PageSize > maxPageSize {
+		return nil, errors.New("page_size must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	params.Sort = strings.ToLower(params.Sort)
+	if params.Sort == "" {
+		params.Sort = defaultSort
+	}
+	params.Order = strings.ToLower(params.Order)
+	if params.Order == "" {
+		params.Order = "asc"
+	}
+	if params.Order != "asc" && params.Order != "desc" {
+		return nil, errors.New("order must be asc or desc")
+	}
+	if params.Bookmark == "" {
+		return nil, nil
+	}
+
+	var mark bookmark
+	markAsBytes, err := base64.URLEncoding.DecodeString(params.Bookmark)
+	if err == nil {
+		err = json.Unmarshal(markAsBytes, &mark)
+	}
+	if err != nil {
+		return nil, errors.New("bookmark is not one this query returned")
+	}
+	if mark.Sort != params.Sort || mark.Order != params.Order {
+		return nil, errors.New("bookmark is for sort " + mark.Sort + " " + mark.Order + ", not " + params.Sort + " " + params.Order)
+	}
+	return &mark, nil
+}
+
+// ============================================================================================================================
+// Page Items - sort the keys and pick the page after the bookmark, returns positions and the next bookmark
+// the bookmark is the last key seen rather than an offset, so items added or removed between calls do not shift pages
+// ============================================================================================================================
+func pageItems(items []keyedItem, params ListParams, mark *bookmark) ([]int, string) {
+	sort.Sort(byKey{items, params.Order == "desc"})
+	start := 0
+	if mark != nil {
+		start = len(items)
+		for i := range items{
+			c := compareKeys(items[i].key, mark.Last)
+			if (params.Order == "asc" && c > 0) || (params.Order == "desc" && c < 0) {
+				start = i
+				break
+			}
+		}
+	}
+	end := start + params.PageSize
+	if end > len(items) {
+		end = len(items)
+	}
+
+	positions := []int{}
+	for i := start; i < end; i++ {
+		positions = append(positions, items[i].pos)
+	}
+	next := ""
+	if end < len(items) {
+		markAsBytes, _ := json.Marshal(bookmark{Sort: params.Sort, Order: params.Order, Last: items[end-1].key})
+		next = base64.URLEncoding.EncodeToString(markAsBytes)
+	}
+	return positions, next
+}
+
+// ============================================================================================================================
+// Marble Sort Key - the key a marble sorts by
+// ============================================================================================================================
+func marbleSortKey(marble Marble, by string) (sortKey, error) {
+	key := sortKey{ID: marble.Name}
+	if by == "name" {
+		key.Str = marble.Name
+	} else if by == "size" {
+		key.Num = int64(marble.Size)
+	} else if by == "color" {
+		key.Str = strings.ToLower(marble.Color)
+	} else if by == "owner" {
+		key.Str = normalizeUser(marble.User)
+	} else {
+		return key, errors.New("Marbles cannot be sorted by " + by + ", expecting name, size, color or owner")
+	}
+	return key, nil
+}
+
+// ============================================================================================================================
+// Trade Sort Key - the key an open trade sorts by, size and color are of the wanted marble
+// ============================================================================================================================
+func tradeSortKey(trade AnOpenTrade, by string) (sortKey, error) {
+	key := sortKey{ID: strconv.FormatInt(trade.Timestamp, 10)}
+	if by == "timestamp" {
+		key.Num = trade.Timestamp
+	} else if by == "size" {
+		key.Num = int64(trade.Want.Size)
+	} else if by == "color" {
+		key.Str = strings.ToLower(trade.Want.Color)
+	} else if by == "owner" {
+		key.Str = normalizeUser(trade.User)
+	} else {
+		return key, errors.New("Trades cannot be sorted by " + by + ", expecting timestamp, size, color or owner")
+	}
+	return key, nil
+}
+
+// ============================================================================================================================
+// List Marbles - read one page of marbles, filtered and sorted
+// ============================================================================================================================
+func (t *Chaincode) list_marbles(stub Stub, args []string) ([]byte, error) {
+
+	//   0
+	// "{"page_size": 10, "sort": "size", "order": "desc", "filter": {"color": "blue"}, "bookmark": ""}"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	var params MarbleListParams
+	err := json.Unmarshal([]byte(args[0]), &params)
+	if err != nil {
+		return nil, errors.New("1st argument must be JSON list parameters")
+	}
+	mark, err := checkListParams(&params.ListParams, "name")
+	if err != nil {
+		return nil, err
+	}
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	query := params.Filter
+	var found []Marble
+	var items []keyedItem
+	for i := range marbleIndex{
+		marble, err := getMarble(stub, marbleIndex[i])
+		if err != nil {
+			continue
+		}
+		if query.Color != "" && strings.ToLower(marble.Color) != strings.ToLower(query.Color) {
+			continue
+		}
+		if query.Size != 0 && marble.Size != query.Size {
+			continue
+		}
+		if query.User != "" && normalizeUser(marble.User) != normalizeUser(query.User) {
+			continue
+		}
+		if !hasAttributes(marble, query.Attributes) {
+			continue
+		}
+		key, err := marbleSortKey(marble, params.Sort)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, keyedItem{key, len(found)})
+		found = append(found, marble)
+	}
+
+	positions, next := pageItems(items, params.ListParams, mark)
+	page := MarblePage{Marbles: []Marble{}, Bookmark: next, Count: len(found)}
+	for _, pos := range positions{
+		page.Marbles = append(page.Marbles, found[pos])
+	}
+	jsonAsBytes, _ := json.Marshal(page)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Trades - read one page of open trades, filtered and sorted
+// ============================================================================================================================
+func (t *Chaincode) list_trades(stub Stub, args []string) ([]byte, error) {
+
+	//   0
+	// "{"page_size": 10, "sort": "timestamp", "order": "desc", "filter": {"user": "bob"}, "bookmark": ""}"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	var params TradeListParams
+	err := json.Unmarshal([]byte(args[0]), &params)
+	if err != nil {
+		return nil, errors.New("1st argument must be JSON list parameters")
+	}
+	mark, err := checkListParams(&params.ListParams, "timestamp")
+	if err != nil {
+		return nil, err
+	}
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get opentrades")
+	}
+	var trades AllTrades
+	json.Unmarshal(tradesAsBytes, &trades)										//un stringify it aka JSON.parse()
+
+	query := params.Filter
+	var found []AnOpenTrade
+	var items []keyedItem
+	for _, trade := range trades.OpenTrades{
+		if query.User != "" && normalizeUser(trade.User) != normalizeUser(query.User) {
+			continue
+		}
+		if query.Color != "" && strings.ToLower(trade.Want.Color) != strings.ToLower(query.Color) {
+			continue
+		}
+		if query.Size != 0 && trade.Want.Size != query.Size {
+			continue
+		}
+		if !hasAttributes(Marble{Attributes: trade.Want.Attributes}, query.Attributes) {
+			continue
+		}
+		key, err := tradeSortKey(trade, params.Sort)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, keyedItem{key, len(found)})
+		found = append(found, trade)
+	}
+
+	positions, next := pageItems(items, params.ListParams, mark)
+	page := TradePage{Trades: []AnOpenTrade{}, Bookmark: next, Count: len(found)}
+	for _, pos := range positions{
+		page.Trades = append(page.Trades, found[pos])
+	}
+	jsonAsBytes, _ := json.Marshal(page)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,136 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+func listMarbles(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, params marbles.ListParams) ([]string, string) {
+	t.Helper()
+	paramsAsBytes, _ := json.Marshal(marbles.MarbleListParams{ListParams: params})
+	res, err, _ := apply(cc, stub, "alice", "query", "list_marbles", []string{string(paramsAsBytes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page marbles.MarblePage
+	json.Unmarshal(res, &page)
+	names := []string{}
+	for _, marble := range page.Marbles{
+		names = append(names, marble.Name)
+	}
+	return names, page.Bookmark
+}
+
+func listTrades(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, params marbles.ListParams) ([]int64, string) {
+	t.Helper()
+	paramsAsBytes, _ := json.Marshal(marbles.TradeListParams{ListParams: params})
+	res, err, _ := apply(cc, stub, "alice", "query", "list_trades", []string{string(paramsAsBytes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page marbles.TradePage
+	json.Unmarshal(res, &page)
+	ids := []int64{}
+	for _, trade := range page.Trades{
+		ids = append(ids, trade.Timestamp)
+	}
+	return ids, page.Bookmark
+}
+
+func TestMarbleBookmarkSurvivesChanges(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, test := range []struct{
+		sort string
+		order string
+		first []string
+		next []string
+	}{
+		{"name", "asc", []string{"m0", "m1", "m2"}, []string{"m2a", "m4", "m5"}},
+		{"name", "desc", []string{"m7", "m6", "m5"}, []string{"m4", "m2a", "m2"}},
+		{"size", "asc", []string{"m0", "m1", "m2"}, []string{"m2a", "m4", "m5"}},		//m2a ties with m2 on size, the name breaks it
+	}{
+		stub := setup(t, cc)
+		for i := 0; i < 8; i++ {
+			mustInvoke(t, cc, stub, "alice", "init_marble", "m" + strconv.Itoa(i), "red", strconv.Itoa(10 + i), "alice")
+		}
+		params := marbles.ListParams{PageSize: 3, Sort: test.sort, Order: test.order}
+		names, mark := listMarbles(t, cc, stub, params)
+		if !reflect.DeepEqual(names, test.first) {
+			t.Fatalf("%s %s: first page %v, want %v", test.sort, test.order, names, test.first)
+		}
+
+		mustInvoke(t, cc, stub, "alice", "burn_marble", test.first[2], "alice")		//the bookmark itself
+		mustInvoke(t, cc, stub, "alice", "burn_marble", "m3", "alice")				//not listed yet
+		mustInvoke(t, cc, stub, "alice", "init_marble", "m2a", "red", "12", "alice")	//lands after the bookmark
+		mustInvoke(t, cc, stub, "alice", "init_marble", "m00", "red", "9", "alice")		//lands before it
+		if test.order == "desc" {
+			mustInvoke(t, cc, stub, "alice", "init_marble", "m8", "red", "18", "alice")
+		}
+		params.Bookmark = mark
+		if names, _ = listMarbles(t, cc, stub, params); !reflect.DeepEqual(names, test.next) {
+			t.Errorf("%s %s: next page %v, want %v", test.sort, test.order, names, test.next)
+		}
+	}
+}
+
+func TestTradeBookmarkSurvivesChanges(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "1")
+		ids = append(ids, stub.Timestamp)
+	}
+	params := marbles.ListParams{PageSize: 2}
+	page, mark := listTrades(t, cc, stub, params)
+	if !reflect.DeepEqual(page, ids[:2]) {
+		t.Fatalf("first page %v, want %v", page, ids[:2])
+	}
+
+	mustInvoke(t, cc, stub, "alice", "remove_trade", strconv.FormatInt(ids[1], 10))	//the bookmark itself
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "1")
+	ids = append(ids, stub.Timestamp)
+	params.Bookmark = mark
+	first := mark
+	page, mark = listTrades(t, cc, stub, params)
+	if !reflect.DeepEqual(page, ids[2:4]) {
+		t.Fatalf("second page %v, want %v", page, ids[2:4])
+	}
+
+	mustInvoke(t, cc, stub, "alice", "remove_trade", strconv.FormatInt(ids[4], 10))	//not listed yet
+	params.Bookmark = mark
+	if page, mark = listTrades(t, cc, stub, params); !reflect.DeepEqual(page, ids[5:]) || mark != "" {
+		t.Fatalf("last page %v with bookmark %q, want %v", page, mark, ids[5:])
+	}
+
+	params.Sort = "size"															//a bookmark only works for the sort it came from
+	params.Bookmark = first
+	paramsAsBytes, _ := json.Marshal(marbles.TradeListParams{ListParams: params})
+	if _, err, _ := apply(cc, stub, "alice", "query", "list_trades", []string{string(paramsAsBytes)}); err == nil {
+		t.Fatal("listed trades by size with a timestamp bookmark")
+	}
+}
@@ -0,0 +1,927 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"time"
+	"strings"
+)
+
+// Stub is the part of the chaincode stub the marbles handlers use, the shim's ChaincodeStub with GetCaller added satisfies it
+type Stub interface {
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	GetCaller() (string, error)				//user who signed the transaction
+}
+
+var marbleIndexStr = "_marbleindex"				//name for the key/value that will store a list of all known marbles
+var openTradesStr = "_opentrades"				//name for the key/value that will store all open trades
+
+type Marble struct{
+	Name string `json:"name"`					//the fieldtags are needed to keep case from bouncing around
+	Color string `json:"color"`
+	Size int `json:"size"`
+	User string `json:"user"`
+	Status string `json:"status,omitempty"`		//empty while the marble exists, "burned", "merged" or "split" once tombstoned
+	Attributes map[string]string `json:"attributes,omitempty"`	//extra metadata, checked against the attribute schema
+	Version int `json:"version,omitempty"`		//layout version the marble was written with, see migrations.go
+}
+
+type Description struct{
+	Color string `json:"color"`
+	Size int `json:"size"`
+	Attributes map[string]string `json:"attributes,omitempty"`	//attributes the marble must also have
+}
+
+type AnOpenTrade struct{
+	User string `json:"user"`					//user who created the open trade order
+	Timestamp int64 `json:"timestamp"`			//utc timestamp of creation
+	Want Description  `json:"want"`				//description of desired marble
+	Willing []Description `json:"willing"`		//array of marbles willing to trade away
+	Price int `json:"price,omitempty"`			//tokens the opener pays on top of (or instead of) a willing marble
+}
+
+type AllTrades struct{
+	OpenTrades []AnOpenTrade `json:"open_trades"`
+	Version int `json:"version,omitempty"`		//layout version the trades were written with, see migrations.go
+}
+
+// ============================================================================================================================
+// Init - reset all the things
+// ============================================================================================================================
+func (t *Chaincode) init(stub Stub, args []string) ([]byte, error) {
+	var Aval int
+	var err error
+
+	//   0       1...
+	// "99", "admin"
+	//on deploy the remaining args become the admins and the deployer must be one of them, after that args[1] must be an admin to reset
+	//deploying over a ledger from before admins existed only names them, the admin then runs migrate
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 2")
+	}
+
+	// Initialize the chaincode
+	Aval, err = strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Expecting integer value for asset holding")
+	}
+	
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(admins) == 0 {												//first init, bootstrap the admins
+		for i:=1; i < len(args); i++ {
+			admins = append(admins, normalizeUser(args[i]))
+		}
+		caller, err := getCaller(stub)
+		if err != nil {
+			return nil, err
+		}
+		if !contains(admins, caller) {
+			return nil, errors.New(caller + " must name themselves as an admin to deploy")
+		}
+		err = putAdmins(stub, admins)
+		if err != nil {
+			return nil, err
+		}
+		legacy, err := isLegacyLedger(stub)
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			fmt.Println("- ledger predates admins, named them and kept the marbles for migrate")
+			return nil, nil
+		}
+	} else if !isAdmin(stub, args[1]) {
+		return nil, errors.New(args[1] + " is not an admin, only an admin can reset")
+	}
+
+	// Write the state to the ledger
+	err = stub.PutState("abc", []byte(strconv.Itoa(Aval)))				//making a test var "abc", I find it handy to read/write to it right away to test the network
+	if err != nil {
+		return nil, err
+	}
+	
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	for i := range marbleIndex{											//delete the marbles too, or their names could never be used again
+		err = stub.DelState(marbleIndex[i])
+		if err != nil {
+			return nil, errors.New("Failed to delete state")
+		}
+	}
+	var empty []string
+	jsonAsBytes, _ := json.Marshal(empty)								//marshal an emtpy array of strings to clear the index
+	err = stub.PutState(marbleIndexStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	
+	var trades AllTrades
+	jsonAsBytes, _ = json.Marshal(trades)								//clear the open trade struct
+	err = stub.PutState(openTradesStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = putBalances(stub, map[string]int{})							//clear the token ledger
+	if err != nil {
+		return nil, err
+	}
+	
+	var treasury Treasury
+	jsonAsBytes, _ = json.Marshal(treasury)								//clear the fee treasury
+	err = stub.PutState(treasuryStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = putStats(stub, Stats{})										//clear the counters
+	if err != nil {
+		return nil, err
+	}
+	
+	err = putSchemaVersion(stub, schemaVersion)							//a fresh ledger is already on the latest layout
+	if err != nil {
+		return nil, err
+	}
+	
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Delete - remove a key/value pair from state
+// ============================================================================================================================
+func (t *Chaincode) Delete(stub Stub, args []string) ([]byte, error) {
+	//   0        1
+	// "admin", "name"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	
+	name := args[1]
+	err := deleteMarble(stub, name)												//remove the key from chaincode state
+	if err != nil {
+		return nil, err
+	}
+
+	//get the marble index
+	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get marble index")
+	}
+	var marbleIndex []string
+	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
+	
+	//remove marble from index
+	for i,val := range marbleIndex{
+		if val == name{															//find the correct marble
+			marbleIndex = append(marbleIndex[:i], marbleIndex[i+1:]...)			//remove it
+			break
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(marbleIndex)									//save new index
+	err = stub.PutState(marbleIndexStr, jsonAsBytes)
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Write - write variable into chaincode state
+// ============================================================================================================================
+func (t *Chaincode) Write(stub Stub, args []string) ([]byte, error) {
+	var name, value string // Entities
+	var err error
+	fmt.Println("running write()")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3. admin, name of the variable and value to set")
+	}
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+
+	name = args[1]															//rename for funsies
+	value = args[2]
+	err = stub.PutState(name, []byte(value))								//write the variable into the chaincode state
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Init Marble - create a new marble, store into chaincode state
+// ============================================================================================================================
+func (t *Chaincode) init_marble(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0       1       2     3       4                 5
+	// "asdf", "blue", "35", "bob", *"minter"*, *"{"material": "glass"}"*
+	if len(args) < 4 || len(args) > 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+
+	fmt.Println("- start init marble")
+	if len(args[0]) <= 0 {
+		return nil, errors.New("1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return nil, errors.New("3rd argument must be a non-empty string")
+	}
+	if len(args[3]) <= 0 {
+		return nil, errors.New("4th argument must be a non-empty string")
+	}
+	size, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, errors.New("3rd argument must be a numeric string")
+	}
+	minter := ""
+	if len(args) > 4 {
+		minter = args[4]
+	}
+	attributes := make(map[string]string)
+	if len(args) > 5 {
+		attributes, err = parseAttributes(args[5])
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := getAttributeSchema(stub)
+	if err != nil {
+		return nil, err
+	}
+	marble, err := checkNewMarble(stub, policy, supply, schema, minter, Marble{Name: args[0], Color: args[1], Size: size, User: args[3], Attributes: attributes})
+	if err != nil {
+		return nil, err
+	}
+	err = putMarble(stub, marble)											//store marble with id as key
+	if err != nil {
+		return nil, err
+	}
+		
+	//get the marble index
+	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get marble index")
+	}
+	var marbleIndex []string
+	json.Unmarshal(marblesAsBytes, &marbleIndex)							//un stringify it aka JSON.parse()
+	
+	//append
+	marbleIndex = append(marbleIndex, args[0])								//add marble name to index list
+	fmt.Println("! marble index: ", marbleIndex)
+	jsonAsBytes, _ := json.Marshal(marbleIndex)
+	err = stub.PutState(marbleIndexStr, jsonAsBytes)						//store name of marble
+
+	fmt.Println("- end init marble")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Check New Marble - errors if this marble may not be minted, returns it with the color, user and attributes cleaned up
+// ============================================================================================================================
+func checkNewMarble(stub Stub, policy MintPolicy, supply Supply, schema AttributeSchema, minter string, marble Marble) (Marble, error) {
+	if strings.HasPrefix(marble.Name, "_") {
+		return marble, errors.New("Marble name " + marble.Name + " must not start with _")
+	}
+	existing := Marble{}
+	existingAsBytes, err := stub.GetState(marble.Name)
+	if err != nil {
+		return marble, errors.New("Failed to get marble " + marble.Name)
+	}
+	json.Unmarshal(existingAsBytes, &existing)								//un stringify it aka JSON.parse()
+	if existing.Status != "" {
+		return marble, errors.New("Marble " + marble.Name + " was " + existing.Status + ", its name cannot be reused")
+	}
+	if existing.Name != "" {
+		return marble, errors.New("Marble " + marble.Name + " already exists")			//remaking it would hand it to a new owner
+	}
+	
+	marble.Color = strings.ToLower(marble.Color)
+	marble.User, err = checkActiveUser(stub, marble.User)
+	if err != nil {
+		return marble, err
+	}
+	if minter != "" {
+		err = checkSigner(stub, minter)										//a minter has to mint for themselves
+		if err != nil {
+			return marble, err
+		}
+	}
+	err = checkMintPolicy(policy, supply, minter, marble.Color, marble.Size, marble.User)
+	if err != nil {
+		return marble, err
+	}
+	err = validateAttributes(schema, marble.Attributes)
+	if err != nil {
+		return marble, err
+	}
+	if len(marble.Attributes) == 0 {
+		marble.Attributes = nil
+	}
+	return marble, nil
+}
+
+// ============================================================================================================================
+// Set User Permission on Marble
+// ============================================================================================================================
+func (t *Chaincode) set_user(stub Stub, args []string) ([]byte, error) {
+	var err error
+	
+	//   0       1
+	// "name", "bob"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	
+	fmt.Println("- start set user")
+	fmt.Println(args[0] + " - " + args[1])
+	res, err := getMarble(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkCaller(stub, res.User, res.Name)							//owner or their operator
+	if err != nil {
+		return nil, err
+	}
+	pending, err := requestApproval(stub, PendingTransfer{Function: "set_user", Args: args, Marbles: []Marble{res}})	//big marbles wait for approvers
+	if err != nil {
+		return nil, err
+	}
+	if pending != "" {
+		fmt.Println("- end set user, waiting on approval " + pending)
+		return []byte(pending), nil
+	}
+	
+	err = transferMarble(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	
+	fmt.Println("- end set user")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// transferMarble - change the owner of a marble, no approval checks
+// ============================================================================================================================
+func transferMarble(stub Stub, name string, user string) error {
+	var err error
+	
+	marbleAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return errors.New("Failed to get thing")
+	}
+	res := Marble{}
+	json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
+	if res.Status != "" {
+		return errors.New("Marble " + name + " is " + res.Status)
+	}
+	from := res.User
+	res.User, err = checkActiveUser(stub, user)								//change the user
+	if err != nil {
+		return err
+	}
+	
+	err = putMarble(stub, res)												//rewrite the marble with id as key
+	if err != nil {
+		return err
+	}
+	setEvent(stub, "marble_transferred", TransferEvent{Name: name, From: from, To: res.User})
+	return nil
+}
+
+// ============================================================================================================================
+// Open Trade - create an open trade for a marble you want with marbles you have 
+// ============================================================================================================================
+func (t *Chaincode) open_trade(stub Stub, args []string) ([]byte, error) {
+	var err error
+	var will_size int
+	var trade_away Description
+	
+	//	0        1      2     3      4      5       6
+	//["bob", "blue", "16", "red", "16"] *"blue", "35*
+	//an even number of args means the last one is a token price, ["bob", "blue", "16", "50"] offers tokens only
+	//a trailing JSON object adds attributes to the descriptions, {"want": {"material": "glass"}, "willing": [{...}]}
+	var options TradeOptions
+	if len(args) > 0 && strings.HasPrefix(args[len(args) - 1], "{") {
+		err = json.Unmarshal([]byte(args[len(args) - 1]), &options)
+		if err != nil {
+			return nil, errors.New("last argument must be a JSON trade options object")
+		}
+		args = args[:len(args) - 1]
+	}
+	if len(args) < 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting like 5?")
+	}
+	price := 0
+	if len(args)%2 == 0{
+		price, err = strconv.Atoi(args[len(args) - 1])
+		if err != nil || price <= 0 {
+			return nil, errors.New("last argument must be a positive numeric price")
+		}
+		args = args[:len(args) - 1]
+	}
+	if len(args) < 5 && price == 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting a willing marble or a price")
+	}
+
+	size1, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, errors.New("3rd argument must be a numeric string")
+	}
+	user, err := checkActiveUser(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkCaller(stub, user, "")											//owner or their operator
+	if err != nil {
+		return nil, err
+	}
+
+	if price > 0 {
+		balances, err := getBalances(stub)
+		if err != nil {
+			return nil, err
+		}
+		if balances[user] < price {
+			return nil, errors.New(args[0] + " does not have enough tokens to offer " + strconv.Itoa(price))
+		}
+	}
+
+	open := AnOpenTrade{}
+	open.User = user
+	open.Timestamp = makeTimestamp(stub)											//use timestamp as an ID
+	open.Want.Color = args[1]
+	open.Want.Size =  size1
+	open.Want.Attributes = options.Want
+	open.Price = price
+	fmt.Println("- start open trade")
+
+	for i:=3; i < len(args); i++ {												//create and append each willing trade
+		will_size, err = strconv.Atoi(args[i + 1])
+		if err != nil {
+			msg := "is not a numeric string " + args[i + 1]
+			fmt.Println(msg)
+			return nil, errors.New(msg)
+		}
+		
+		trade_away = Description{}
+		trade_away.Color = args[i]
+		trade_away.Size =  will_size
+		if len(open.Willing) < len(options.Willing) {
+			trade_away.Attributes = options.Willing[len(open.Willing)]
+		}
+		open.Willing = append(open.Willing, trade_away)
+		i++;
+	}
+	
+	//get the open trade struct
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get opentrades")
+	}
+	var trades AllTrades
+	json.Unmarshal(tradesAsBytes, &trades)										//un stringify it aka JSON.parse()
+	for i := range trades.OpenTrades{											//trades opened in the same millisecond still need their own ID
+		if trades.OpenTrades[i].Timestamp >= open.Timestamp {
+			open.Timestamp = trades.OpenTrades[i].Timestamp + 1
+		}
+	}
+	for hasReceipt(stub, open.Timestamp) {										//nor may it reuse the ID of a completed trade
+		open.Timestamp++
+	}
+	
+	trades.OpenTrades = append(trades.OpenTrades, open);						//append to open trades
+	fmt.Println("! appended open to trades")
+	err = putTrades(stub, trades)												//rewrite open orders
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end open trade")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Perform Trade - close an open trade and move ownership
+// ============================================================================================================================
+func (t *Chaincode) perform_trade(stub Stub, args []string) ([]byte, error) {
+	return t.performTrade(stub, args, nil)
+}
+
+// ============================================================================================================================
+// performTrade - close the trade, unless approved it first checks if the marbles need multi-sig approval
+// ============================================================================================================================
+func (t *Chaincode) performTrade(stub Stub, args []string, approved *PendingTransfer) ([]byte, error) {
+	var err error
+	
+	//	0			1					2
+	//[data.id, data.closer.name, data.willing.index]
+	//the closer is whoever owns the marble and must sign or be their operator, a tokens only trade takes index -1
+	//an approved trade settles with the opener's marble, price and fee pinned when approval was asked for
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	
+	fmt.Println("- start close trade")
+	policy, err := t.getTradePolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("1st argument must be a numeric string")
+	}
+	used, err := strconv.Atoi(args[2])																	//willing option the opener gives up
+	if err != nil {
+		return nil, errors.New("3rd argument must be a numeric string")
+	}
+	closersMarble, err := getMarble(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if closersMarble.Status != "" {
+		return nil, errors.New("Marble " + closersMarble.Name + " is " + closersMarble.Status)
+	}
+	closer, err := checkActiveUser(stub, closersMarble.User)
+	if err != nil {
+		return nil, err
+	}
+	if approved == nil {																				//checked when the approval was asked for
+		err = checkCaller(stub, closer, closersMarble.Name)												//owner or their operator
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	//get the open trade struct
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get opentrades")
+	}
+	var trades AllTrades
+	json.Unmarshal(tradesAsBytes, &trades)																//un stringify it aka JSON.parse()
+	
+	found := false
+	for i := range trades.OpenTrades{																		//look for the trade
+		if trades.OpenTrades[i].Timestamp == timestamp{
+			fmt.Println("found the trade");
+			found = true
+			if normalizeUser(trades.OpenTrades[i].User) == closer {
+				return nil, errors.New("Cannot close your own trade, remove it instead")
+			}
+			
+			var marble Marble
+			if len(trades.OpenTrades[i].Willing) == 0 && used != -1 {
+				return nil, errors.New("Trade " + args[0] + " has no willing options, expecting index -1")
+			}
+			if len(trades.OpenTrades[i].Willing) > 0 {
+				if used < 0 || used >= len(trades.OpenTrades[i].Willing) {
+					return nil, errors.New("Trade " + args[0] + " has no willing option " + args[2])
+				}
+				if approved != nil {
+					marble, err = getMarble(stub, approved.OpenerMarble)									//the one the approvers saw
+					if err == nil && !matchesDescription(marble, trades.OpenTrades[i].Willing[used]) {
+						err = errors.New("Trade " + args[0] + " changed since the transfer was requested, reject it instead")
+					}
+				} else {
+					marble, err = findMarble4Trade(stub, trades.OpenTrades[i].User, trades.OpenTrades[i].Willing[used])	//find a marble that is suitable from opener
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			fmt.Println("! no errors, proceeding")
+			_, err = checkActiveUser(stub, trades.OpenTrades[i].User)									//opener may have been suspended since
+			if err != nil {
+				return nil, err
+			}
+			if policy.StrictWant && !matchesDescription(closersMarble, trades.OpenTrades[i].Want) {		//verify if marble meets trade requirements
+				msg := "marble in input does not meet trade requriements"
+				fmt.Println(msg)
+				return nil, errors.New(msg)
+			}
+
+			price := trades.OpenTrades[i].Price
+			fee, payer, err := tradeFee(stub, closersMarble.Size + marble.Size)
+			if err != nil {
+				return nil, err
+			}
+			if approved != nil {
+				price, fee, payer = approved.Price, approved.Fee, approved.FeePayer
+			} else {																					//big marbles wait for approvers
+				request := PendingTransfer{Function: "perform_trade", Args: args, Marbles: []Marble{closersMarble, marble}, OpenerMarble: marble.Name, Price: price, Fee: fee, FeePayer: payer}
+				pending, err := requestApproval(stub, request)
+				if err != nil {
+					return nil, err
+				}
+				if pending != "" {
+					fmt.Println("- end close trade, waiting on approval " + pending)
+					return []byte(pending), nil
+				}
+			}
+
+			balances, err := getBalances(stub)															//settle tokens before anything moves
+			if err != nil {
+				return nil, err
+			}
+			err = moveTokens(balances, trades.OpenTrades[i].User, closer, price)
+			if err != nil {
+				return nil, err
+			}
+			err = payTradeFee(balances, payer, trades.OpenTrades[i].User, closer, fee)
+			if err != nil {
+				return nil, err
+			}
+			if price > 0 || fee > 0 {
+				err = putBalances(stub, balances)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if fee > 0 {
+				err = collectFee(stub, fee)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			err = transferMarble(stub, closersMarble.Name, trades.OpenTrades[i].User)					//change owner of selected marble, closer -> opener
+			if err != nil {
+				return nil, err
+			}
+			if marble.Name != "" {
+				err = transferMarble(stub, marble.Name, closer)											//change owner of selected marble, opener -> closer
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = countCompletedTrade(stub)
+			if err != nil {
+				return nil, err
+			}
+			receipt := Receipt{ID: timestamp, TradeID: timestamp, Opener: normalizeUser(trades.OpenTrades[i].User), Closer: closer, ToOpener: []Marble{closersMarble}, ToCloser: []Marble{}, Price: price, Fee: fee, Timestamp: makeTimestamp(stub)}
+			if marble.Name != "" {
+				receipt.ToCloser = append(receipt.ToCloser, marble)
+			}
+			partial := policy.AllowPartial && used >= 0 && len(trades.OpenTrades[i].Willing) > 1
+			if partial || hasReceipt(stub, receipt.ID) {												//the trade was filled before, or will be again
+				receipt.ID = newReceiptID(stub, trades)
+			}
+			err = recordReceipt(stub, receipt)															//keep a record of what happened
+			if err != nil {
+				return nil, err
+			}
+			
+			if partial {
+				trades.OpenTrades[i].Willing = append(trades.OpenTrades[i].Willing[:used], trades.OpenTrades[i].Willing[used+1:]...)	//remove just the used option
+			} else {
+				trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)			//remove trade
+			}
+			err = putTrades(stub, trades)																//rewrite open orders
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("No open trade " + args[0])
+	}
+	fmt.Println("- end close trade")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// findMarble4Trade - look for a matching marble that this user owns and return it
+// ============================================================================================================================
+func findMarble4Trade(stub Stub, user string, desc Description)(m Marble, err error){
+	var fail Marble;
+	fmt.Println("- start find marble 4 trade")
+	fmt.Println("looking for " + user + ", " + desc.Color + ", " + strconv.Itoa(desc.Size));
+
+	//get the marble index
+	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if err != nil {
+		return fail, errors.New("Failed to get marble index")
+	}
+	var marbleIndex []string
+	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
+	
+	for i:= range marbleIndex{													//iter through all the marbles
+		//fmt.Println("looking @ marble name: " + marbleIndex[i]);
+
+		marbleAsBytes, err := stub.GetState(marbleIndex[i])						//grab this marble
+		if err != nil {
+			return fail, errors.New("Failed to get marble")
+		}
+		res := Marble{}
+		json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
+		//fmt.Println("looking @ " + res.User + ", " + res.Color + ", " + strconv.Itoa(res.Size));
+		
+		//check for user && color && size && attributes
+		if normalizeUser(res.User) == normalizeUser(user) && matchesDescription(res, desc){
+			fmt.Println("found a marble: " + res.Name)
+			fmt.Println("! end find marble 4 trade")
+			return res, nil
+		}
+	}
+	
+	fmt.Println("- end find marble 4 trade - error")
+	return fail, errors.New("Did not find marble to use in this trade")
+}
+
+// TimestampStub is a stub that knows when its transaction happened, in ms, stubs without it get the clock
+type TimestampStub interface {
+	TxTimestamp() (int64, error)
+}
+
+// ============================================================================================================================
+// Tx Timestamp - the transaction's own timestamp in ms, errors if the stub has none
+// ============================================================================================================================
+func txTimestamp(stub Stub) (int64, error) {
+	if timestampStub, ok := stub.(TimestampStub); ok {
+		return timestampStub.TxTimestamp()
+	}
+	return 0, errors.New("Stub has no transaction timestamp")
+}
+
+// ============================================================================================================================
+// Make Timestamp - create a timestamp in ms, the transaction's own if the stub has one
+// ============================================================================================================================
+func makeTimestamp(stub Stub) int64 {
+	timestamp, err := txTimestamp(stub)
+	if err == nil {
+		return timestamp
+	}
+    return time.Now().UnixNano() / (int64(time.Millisecond)/int64(time.Nanosecond))
+}
+
+// ============================================================================================================================
+// Remove Open Trade - close an open trade
+// ============================================================================================================================
+func (t *Chaincode) remove_trade(stub Stub, args []string) ([]byte, error) {
+	var err error
+	
+	//	0
+	//[data.id]
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	
+	fmt.Println("- start remove trade")
+	timestamp, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("1st argument must be a numeric string")
+	}
+	
+	//get the open trade struct
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get opentrades")
+	}
+	var trades AllTrades
+	json.Unmarshal(tradesAsBytes, &trades)																//un stringify it aka JSON.parse()
+	
+	for i := range trades.OpenTrades{																	//look for the trade
+		//fmt.Println("looking at " + strconv.FormatInt(trades.OpenTrades[i].Timestamp, 10) + " for " + strconv.FormatInt(timestamp, 10))
+		if trades.OpenTrades[i].Timestamp == timestamp{
+			fmt.Println("found the trade");
+			err = checkCaller(stub, trades.OpenTrades[i].User, "")										//owner or their operator
+			if err != nil {
+				return nil, err
+			}
+			trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)				//remove this trade
+			err = putTrades(stub, trades)																//rewrite open orders
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	
+	fmt.Println("- end remove trade")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Clean Up Open Trades - make sure open trades are still possible, remove choices that are no longer possible, remove trades that have no valid choices
+// only the trades of these owners are looked at, nil looks at every trade
+// ============================================================================================================================
+func cleanTrades(stub Stub, owners map[string]bool)(err error){
+	var didWork = false
+	fmt.Println("- start clean trades")
+	if owners != nil && len(owners) == 0 {
+		fmt.Println("- end clean trades, no holdings changed")
+		return nil
+	}
+	
+	//get the open trade struct
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return errors.New("Failed to get opentrades")
+	}
+	var trades AllTrades
+	json.Unmarshal(tradesAsBytes, &trades)																		//un stringify it aka JSON.parse()
+	balances, err := getBalances(stub)
+	if err != nil {
+		return err
+	}
+	
+	fmt.Println("# trades " + strconv.Itoa(len(trades.OpenTrades)))
+	for i:=0; i<len(trades.OpenTrades); {																		//iter over all the known open trades
+		if owners != nil && !owners[normalizeUser(trades.OpenTrades[i].User)] {
+			i++																							//their holdings did not change
+			continue
+		}
+		if trades.OpenTrades[i].Price > balances[normalizeUser(trades.OpenTrades[i].User)] {					//the price is paid on top of any option
+			fmt.Println("! opener cannot cover the price, removing trade")
+			didWork = true
+			trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)					//remove this trade
+			continue
+		}
+		
+		var removed = 0
+		for x:=0; x<len(trades.OpenTrades[i].Willing); {														//find a marble that is suitable
+			_, e := findMarble4Trade(stub, trades.OpenTrades[i].User, trades.OpenTrades[i].Willing[x])
+			if(e != nil){
+				fmt.Println("! errors with this option, removing option")
+				didWork = true
+				removed++
+				trades.OpenTrades[i].Willing = append(trades.OpenTrades[i].Willing[:x], trades.OpenTrades[i].Willing[x+1:]...)	//remove this option
+				x--;
+			}
+			
+			x++
+			if x >= len(trades.OpenTrades[i].Willing) {														//things might have shifted, recalcuate
+				break
+			}
+		}
+		
+		if len(trades.OpenTrades[i].Willing) == 0 && (removed > 0 || trades.OpenTrades[i].Price == 0) {	//a tokens only trade has no options to lose
+			fmt.Println("! no more options for this trade, removing trade")
+			didWork = true
+			trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)					//remove this trade
+			i--;
+		}
+		
+		i++
+		if i >= len(trades.OpenTrades) {																	//things might have shifted, recalcuate
+			break
+		}
+	}
+
+	if(didWork){
+		fmt.Println("! saving open trade changes")
+		err = putTrades(stub, trades)																		//rewrite open orders
+		if err != nil {
+			return err
+		}
+	}else{
+		fmt.Println("! all open trades are fine")
+	}
+
+	fmt.Println("- end clean trades")
+	return nil
+}
\ No newline at end of file
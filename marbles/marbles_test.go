@@ -0,0 +1,300 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"strconv"
+	"encoding/json"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+func TestResetFreesMarbleNames(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "admin", "init", "1", "admin")
+	if value, _ := stub.GetState("m1"); value != nil {
+		t.Fatalf("reset left m1 behind: %s", value)
+	}
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m1", "blue", "5", "bob")
+}
+
+func TestOpenTradeWritesOnlyTrades(t *testing.T) {
+	for _, preset := range []marbles.Preset{marbles.Part2, marbles.Experimental}{
+		cc := marbles.NewChaincode(preset)
+		stub := setup(t, cc)
+		mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+		before := stub.Snapshot()
+		mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16")
+		for _, write := range memstub.Diff(before, stub.Snapshot()){
+			if write.Key != "_opentrades" && write.Key != "_stats" {
+				t.Errorf("%s: open_trade wrote %s", preset.Name, write.Key)
+			}
+		}
+	}
+}
+
+func TestCallerMustOwnOrOperate(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := tradingLedger(t, cc)
+	ids := openTrades(t, cc, stub)
+	id := strconv.FormatInt(ids[0].Timestamp, 10)
+	for _, call := range [][]string{
+		{"set_user", "m1", "dave"},
+		{"transfer_marbles", `[{"name": "m1", "user": "dave"}]`},
+		{"open_trade", "alice", "blue", "16", "red", "16"},
+		{"remove_trade", id},
+		{"perform_trade", id, "m2", "0"},
+	}{
+		for _, caller := range []string{"", "carol"}{
+			mustFail(t, cc, stub, caller, call...)
+		}
+	}
+
+	mustInvoke(t, cc, stub, "bob", "approve_operator", "bob", "carol", "0")
+	mustInvoke(t, cc, stub, "carol", "perform_trade", id, "m2", "0")				//an operator for every marble may trade
+}
+
+func TestOperatorsMustBeActiveAndUnexpired(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "alice", "approve_operator", "alice", "carol", "0")
+	mustInvoke(t, cc, stub, "admin", "set_user_status", "admin", "carol", "suspended")
+	mustFail(t, cc, stub, "carol", "set_user", "m1", "bob")
+	mustInvoke(t, cc, stub, "admin", "set_user_status", "admin", "carol", "active")
+	mustInvoke(t, cc, stub, "carol", "set_user", "m1", "bob")
+
+	mustInvoke(t, cc, stub, "bob", "approve_operator", "bob", "dave", "4102444800000")	//2100, the clock would not expire it
+	now := stub.Timestamp
+	stub.Timestamp = -1000														//apply moves it on to 0, no transaction timestamp
+	mustFail(t, cc, stub, "dave", "set_user", "m1", "alice")
+	stub.Timestamp = now
+	mustInvoke(t, cc, stub, "dave", "set_user", "m1", "carol")
+	mustInvoke(t, cc, stub, "carol", "set_user", "m1", "bob")
+	stub.Timestamp = 4102444800000
+	mustFail(t, cc, stub, "dave", "set_user", "m1", "alice")
+}
+
+func TestAdminsMustSign(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := memstub.New()
+	mustFail(t, cc, stub, "mallory", "init", "1", "admin", "root")					//deployer is not one of the admins
+	mustInvoke(t, cc, stub, "admin", "init", "1", "admin", "root", "ops")
+	mustInvoke(t, cc, stub, "admin", "add_admin", "admin", "mallory")
+	for _, voter := range []string{"root", "ops"}{									//the same signer voting as the others
+		mustFail(t, cc, stub, "admin", "add_admin", voter, "mallory")
+	}
+	mustFail(t, cc, stub, "mallory", "write", "admin", "abc", "2")
+	mustInvoke(t, cc, stub, "root", "add_admin", "root", "mallory")
+	mustInvoke(t, cc, stub, "mallory", "write", "mallory", "abc", "2")
+}
+
+func TestApproversMustSign(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_approval_rules", "admin", `{"rules": [{"min_size": 35, "approvers": ["alice", "carol"], "required": 2}]}`)
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m1", "red", "35", "bob")
+	id := string(mustInvoke(t, cc, stub, "bob", "set_user", "m1", "dave"))
+
+	mustFail(t, cc, stub, "carol", "approve_transfer", id, "alice")					//one approver signing for both
+	mustFail(t, cc, stub, "bob", "reject_transfer", id, "carol")
+	mustInvoke(t, cc, stub, "carol", "approve_transfer", id, "carol")
+	mustFail(t, cc, stub, "carol", "approve_transfer", id, "alice")
+	mustInvoke(t, cc, stub, "alice", "approve_transfer", id, "alice")
+	var marble marbles.Marble
+	marbleAsBytes, _ := stub.GetState("m1")
+	json.Unmarshal(marbleAsBytes, &marble)
+	if marble.User != "dave" {
+		t.Fatalf("m1 is %s's after both approvers signed", marble.User)
+	}
+}
+
+func TestApprovedTradeSettlesAsRequested(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_approval_rules", "admin", `{"rules": [{"min_size": 35, "approvers": ["dave"], "required": 1}]}`)
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "2", "opener")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m0", "red", "16", "bob")		//ahead of m1 in the index
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "carol", "init_marble", "m2", "blue", "35", "carol")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "35", "red", "16", "5")
+	id := strconv.FormatInt(openTrades(t, cc, stub)[0].Timestamp, 10)
+	pending := string(mustInvoke(t, cc, stub, "carol", "perform_trade", id, "m2", "0"))
+
+	mustInvoke(t, cc, stub, "bob", "set_user", "m0", "alice")						//alice now has a matching marble found first
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "50", "opener")
+	mustInvoke(t, cc, stub, "dave", "approve_transfer", pending, "dave")
+	checkOwner(t, stub, "m1", "carol")
+	checkOwner(t, stub, "m0", "alice")
+	checkOwner(t, stub, "m2", "alice")
+	checkBalance(t, cc, stub, "alice", 93)											//the price and the fee of 2 the approver saw
+	checkBalance(t, cc, stub, "carol", 105)
+}
+
+func TestUncoveredPriceClosesTrade(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := tradingLedger(t, cc)												//bob offers 10 tokens for a green 35
+	mustFail(t, cc, stub, "alice", "transfer_tokens", "bob", "alice", "95")		//only bob spends his tokens
+	mustInvoke(t, cc, stub, "bob", "transfer_tokens", "bob", "alice", "95")
+	for _, trade := range openTrades(t, cc, stub){
+		if trade.User == "bob" {
+			t.Fatalf("bob's trade for %d tokens is still open with 5 left", trade.Price)
+		}
+	}
+	broken, err := marbles.CheckInvariants(stub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range broken{
+		t.Error(msg)
+	}
+}
+
+func TestTokensGoToActiveUsers(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_user_status", "admin", "carol", "suspended")
+	for _, to := range []string{"erin", "carol"}{									//unregistered and suspended
+		mustFail(t, cc, stub, "admin", "mint_tokens", "admin", to, "10")
+		mustFail(t, cc, stub, "bob", "transfer_tokens", "bob", to, "10")
+	}
+	mustInvoke(t, cc, stub, "admin", "mint_tokens", "admin", "dave", "10")
+	mustInvoke(t, cc, stub, "bob", "transfer_tokens", "bob", "dave", "10")
+	checkBalance(t, cc, stub, "dave", 120)
+}
+
+func TestOpenerCannotCloseOwnTrade(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "2", "both")
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m2", "blue", "16", "alice")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16")
+	id := strconv.FormatInt(openTrades(t, cc, stub)[0].Timestamp, 10)
+	mustFail(t, cc, stub, "alice", "perform_trade", id, "m2", "0")
+	mustInvoke(t, cc, stub, "alice", "approve_operator", "alice", "bob", "0")
+	mustFail(t, cc, stub, "bob", "perform_trade", id, "m2", "0")					//nor can their operator
+	if len(openTrades(t, cc, stub)) != 1 {
+		t.Fatal("the trade was closed")
+	}
+}
+
+func TestReshapingKeepsMintPolicy(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, test := range []struct{
+		policy string
+		call []string
+		ok bool
+	}{
+		{`{}`, []string{"split_marble", "m1", "alice", "a", "8", "b", "8"}, true},
+		{`{"max_total": 3}`, []string{"split_marble", "m1", "alice", "a", "8", "b", "8"}, true},
+		{`{"max_total": 3}`, []string{"split_marble", "m1", "alice", "a", "6", "b", "5", "c", "5"}, false},
+		{`{"color_caps": {"red": 2}}`, []string{"split_marble", "m1", "alice", "a", "6", "b", "5", "c", "5"}, false},
+		{`{"user_cap": 2}`, []string{"split_marble", "m1", "alice", "a", "8", "b", "8"}, false},
+		{`{"user_caps": {"alice": 3}}`, []string{"split_marble", "m1", "alice", "a", "8", "b", "8"}, true},
+		{`{"min_size": 5}`, []string{"split_marble", "m1", "alice", "a", "12", "b", "4"}, false},
+		{`{"max_size": 20}`, []string{"merge_marbles", "m1", "m2", "alice"}, false},
+		{`{"max_size": 30}`, []string{"merge_marbles", "m1", "m2", "alice"}, true},
+	}{
+		stub := setup(t, cc)
+		mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+		mustInvoke(t, cc, stub, "alice", "init_marble", "m2", "red", "10", "alice")
+		mustInvoke(t, cc, stub, "admin", "set_mint_policy", "admin", test.policy)
+		if test.ok {
+			mustInvoke(t, cc, stub, "alice", test.call...)
+		} else {
+			mustFail(t, cc, stub, "alice", test.call...)
+		}
+	}
+}
+
+func TestUserMintCap(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_mint_policy", "admin", `{"user_cap": 1}`)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m2", "red", "16", "bob")
+	mustInvoke(t, cc, stub, "bob", "set_user", "m2", "alice")						//only minting is capped
+	mustFail(t, cc, stub, "alice", "init_marble", "m3", "red", "16", "alice")
+
+	var stats marbles.SupplyStats
+	res, err, _ := apply(cc, stub, "alice", "query", "supply_stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(res, &stats)
+	if stats.Total.Count != 2 || stats.Users["alice"] != (marbles.SupplyCount{Count: 2, Cap: 1}) || stats.Colors["red"].Count != 2 {
+		t.Fatalf("supply is %+v", stats)
+	}
+}
+
+func TestSplitPiecesKeepAttributes(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice", "", `{"material": "glass"}`)
+	mustInvoke(t, cc, stub, "alice", "split_marble", "m1", "alice", "a", "8", "b", "8")
+	for _, name := range []string{"a", "b"}{
+		var marble marbles.Marble
+		marbleAsBytes, _ := stub.GetState(name)
+		json.Unmarshal(marbleAsBytes, &marble)
+		if marble.Attributes["material"] != "glass" {
+			t.Fatalf("piece %s has attributes %v", name, marble.Attributes)
+		}
+	}
+
+	mustInvoke(t, cc, stub, "admin", "set_attribute_schema", "admin", `{"attributes": {"material": {"type": "string"}, "serial": {"type": "int", "required": true}}}`)
+	mustFail(t, cc, stub, "alice", "split_marble", "a", "alice", "c", "4", "d", "4")	//the pieces would lack a required serial
+}
+
+func TestOwnerMustSignReshaping(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m2", "red", "10", "alice")
+	for _, call := range [][]string{
+		{"burn_marble", "m1", "alice"},
+		{"merge_marbles", "m1", "m2", "alice"},
+		{"split_marble", "m1", "alice", "a", "8", "b", "8"},
+		{"update_attributes", "m1", "alice", `{"material": "glass"}`},
+	}{
+		mustFail(t, cc, stub, "bob", call...)
+	}
+	mustInvoke(t, cc, stub, "alice", "burn_marble", "m1", "alice")
+}
+
+func TestMinterMustSign(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_minter", "admin", "carol", "true")
+	mustFail(t, cc, stub, "bob", "init_marble", "m1", "red", "16", "bob", "carol")
+	mustFail(t, cc, stub, "bob", "init_marbles", `[{"name": "m1", "color": "red", "size": 16, "user": "bob"}]`, "carol")
+	mustInvoke(t, cc, stub, "carol", "init_marble", "m1", "red", "16", "bob", "carol")
+}
+
+func TestUsersRegisterThemselves(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustFail(t, cc, stub, "bob", "register_user", "erin", "Erin", "co")
+	mustInvoke(t, cc, stub, "erin", "register_user", "erin", "Erin", "co")
+}
@@ -0,0 +1,255 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"strings"
+)
+
+var schemaStr = "_schema"						//name for the key/value that will store the layout version of the ledger
+var schemaVersion = 3							//layout this code writes, bump and add a migration step when it changes
+
+// layout versions
+// 1 - part1, marbles and _marbleindex only
+// 2 - part2 as first released, adds _opentrades
+// 3 - registered users, admins, tombstones, attributes, stats and version stamped marbles and trades
+
+type Schema struct{
+	Version int `json:"version"`
+}
+
+type MigrationStep struct{
+	From int									//version the step upgrades from, it leaves the ledger at From + 1
+	Name string
+	Run func(stub Stub) error	//must be safe to run again on a ledger it already upgraded
+}
+
+type MigrationReport struct{
+	From int `json:"from"`
+	To int `json:"to"`
+	Steps []string `json:"steps"`				//names of the steps that ran
+}
+
+var migrations = []MigrationStep{
+	{From: 1, Name: "add open trades", Run: migrateAddOpenTrades},
+	{From: 2, Name: "register owners, normalize and stamp marbles and trades", Run: migrateStampDocuments},
+}
+
+// ============================================================================================================================
+// Get Schema Version - read the layout version, older ledgers never wrote one so work it out from what is there
+// ============================================================================================================================
+func getSchemaVersion(stub Stub) (int, error) {
+	schemaAsBytes, err := stub.GetState(schemaStr)
+	if err != nil {
+		return 0, errors.New("Failed to get schema version")
+	}
+	if schemaAsBytes != nil {
+		var schema Schema
+		json.Unmarshal(schemaAsBytes, &schema)									//un stringify it aka JSON.parse()
+		return schema.Version, nil
+	}
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return 0, errors.New("Failed to get opentrades")
+	}
+	if tradesAsBytes == nil {
+		return 1, nil
+	}
+	return 2, nil
+}
+
+// ============================================================================================================================
+// Is Legacy Ledger - true if marbles were written before the layout was versioned, init must not wipe them
+// ============================================================================================================================
+func isLegacyLedger(stub Stub) (bool, error) {
+	schemaAsBytes, err := stub.GetState(schemaStr)
+	if err != nil {
+		return false, errors.New("Failed to get schema version")
+	}
+	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if err != nil {
+		return false, errors.New("Failed to get marble index")
+	}
+	return schemaAsBytes == nil && marblesAsBytes != nil, nil
+}
+
+// ============================================================================================================================
+// Put Schema Version - record the layout version
+// ============================================================================================================================
+func putSchemaVersion(stub Stub, version int) error {
+	jsonAsBytes, _ := json.Marshal(Schema{Version: version})
+	return stub.PutState(schemaStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Migrate Add Open Trades - 1 -> 2, part1 never wrote the open trades
+// ============================================================================================================================
+func migrateAddOpenTrades(stub Stub) error {
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return errors.New("Failed to get opentrades")
+	}
+	if tradesAsBytes != nil {
+		return nil
+	}
+	var trades AllTrades
+	jsonAsBytes, _ := json.Marshal(trades)
+	return stub.PutState(openTradesStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Migrate Stamp Documents - 2 -> 3, register every owner, tidy the index, normalize and stamp marbles and trades
+// ============================================================================================================================
+func migrateStampDocuments(stub Stub) error {
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return err
+	}
+	trades, err := getTrades(stub)
+	if err != nil {
+		return err
+	}
+	users, err := getUsers(stub)
+	if err != nil {
+		return err
+	}
+	register := func(user string) {											//owners from before the registry get an active record
+		id := normalizeUser(user)
+		if _, ok := users[id]; !ok && id != "" {
+			users[id] = User{ID: id, DisplayName: user, Status: "active", Created: makeTimestamp(stub)}
+		}
+	}
+
+	var kept []string
+	seen := make(map[string]bool)
+	for i := range marbleIndex{
+		marble, err := getMarble(stub, marbleIndex[i])
+		if err != nil || seen[marble.Name] {
+			continue															//index points at a deleted key or lists it twice
+		}
+		seen[marble.Name] = true
+		kept = append(kept, marble.Name)
+		register(marble.User)
+		marble.User = normalizeUser(marble.User)
+		marble.Color = strings.ToLower(marble.Color)
+		err = putMarble(stub, marble)											//stamps the version
+		if err != nil {
+			return err
+		}
+	}
+	if kept == nil {
+		kept = []string{}
+	}
+	err = putMarbleIndex(stub, kept)
+	if err != nil {
+		return err
+	}
+
+	for i := range trades.OpenTrades{
+		register(trades.OpenTrades[i].User)
+		trades.OpenTrades[i].User = normalizeUser(trades.OpenTrades[i].User)
+	}
+	for i := range trades.OpenTrades{											//old trades could share an ID
+		for x := 0; x < i; x++ {
+			if trades.OpenTrades[x].Timestamp == trades.OpenTrades[i].Timestamp {
+				trades.OpenTrades[i].Timestamp++
+				x = -1															//check again from the start
+			}
+		}
+	}
+	err = putTrades(stub, trades)												//stamps the version
+	if err != nil {
+		return err
+	}
+	err = putUsers(stub, users)
+	if err != nil {
+		return err
+	}
+	return rebuildStats(stub)													//older ledgers never kept counters
+}
+
+// ============================================================================================================================
+// Migrate - admin upgrades the ledger layout, running each step it has not had yet
+// a ledger from before admins existed gets them from deploying with init first, migrate never makes one
+// ============================================================================================================================
+func (t *Chaincode) migrate(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0         1
+	// "admin", *"3"*
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start migrate")
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(admins) == 0 {
+		return nil, errors.New("Ledger has no admins, deploy with init naming them before migrating")
+	}
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	target := schemaVersion
+	if len(args) > 1 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil || target < 1 || target > schemaVersion {
+			return nil, errors.New("2nd argument must be a version from 1 to " + strconv.Itoa(schemaVersion))
+		}
+	}
+	version, err := getSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	if version > schemaVersion {
+		return nil, errors.New("Ledger is at version " + strconv.Itoa(version) + ", newer than this code")
+	}
+	if version > target {
+		return nil, errors.New("Ledger is already at version " + strconv.Itoa(version) + ", cannot migrate down")
+	}
+
+	report := MigrationReport{From: version, To: version, Steps: []string{}}
+	for _, step := range migrations{
+		if step.From < report.To || step.From >= target {
+			continue
+		}
+		fmt.Println("! running migration " + step.Name)
+		err = step.Run(stub)
+		if err != nil {
+			return nil, errors.New("Migration " + step.Name + " failed: " + err.Error())
+		}
+		report.To = step.From + 1
+		report.Steps = append(report.Steps, step.Name)
+	}
+	err = putSchemaVersion(stub, report.To)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end migrate")
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,113 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// ============================================================================================================================
+// Part1 Ledger - what part1 left behind, marbles and their index only, with the mess older code allowed
+// ============================================================================================================================
+func part1Ledger() *memstub.Stub {
+	stub := memstub.New()
+	stub.Timestamp = 1464000000000
+	stub.PutState("_marbleindex", []byte(`["m1","m2","m1","gone"]`))				//listed twice and a deleted key
+	stub.PutState("m1", []byte(`{"name":"m1","color":"Red","size":16,"user":"Bob"}`))
+	stub.PutState("m2", []byte(`{"name":"m2","color":"blue","size":35,"user":"alice"}`))
+	return stub
+}
+
+func TestMigrateNeedsDeployedAdmins(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := part1Ledger()
+	mustFail(t, cc, stub, "mallory", "migrate", "mallory")						//no admins, nobody gets to be the first
+	if admins, _ := stub.GetState("_admins"); admins != nil {
+		t.Fatalf("migrate made admins %s", admins)
+	}
+
+	mustInvoke(t, cc, stub, "admin", "init", "1", "admin")
+	if marble, _ := stub.GetState("m1"); marble == nil {
+		t.Fatal("deploying over a part1 ledger wiped its marbles")
+	}
+	mustFail(t, cc, stub, "mallory", "migrate", "mallory")
+	mustFail(t, cc, stub, "mallory", "migrate", "admin")
+}
+
+func TestMigratePart1ToLatest(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := part1Ledger()
+	mustInvoke(t, cc, stub, "admin", "init", "1", "admin")
+
+	for _, step := range []struct{
+		target string
+		report marbles.MigrationReport
+	}{
+		{"2", marbles.MigrationReport{From: 1, To: 2, Steps: []string{"add open trades"}}},
+		{"3", marbles.MigrationReport{From: 2, To: 3, Steps: []string{"register owners, normalize and stamp marbles and trades"}}},
+	}{
+		var report marbles.MigrationReport
+		json.Unmarshal(mustInvoke(t, cc, stub, "admin", "migrate", "admin", step.target), &report)
+		if !reflect.DeepEqual(report, step.report) {
+			t.Fatalf("migrate to %s: %+v, want %+v", step.target, report, step.report)
+		}
+		migrated := stub.Snapshot()
+
+		json.Unmarshal(mustInvoke(t, cc, stub, "admin", "migrate", "admin", step.target), &report)
+		if len(report.Steps) != 0 {
+			t.Fatalf("migrate to %s again ran %v", step.target, report.Steps)
+		}
+		schemaAsBytes, _ := json.Marshal(marbles.Schema{Version: step.report.From})	//as if the step had not been recorded
+		stub.PutState("_schema", schemaAsBytes)
+		mustInvoke(t, cc, stub, "admin", "migrate", "admin", step.target)
+		for _, write := range memstub.Diff(migrated, stub.Snapshot()){
+			t.Errorf("running %q twice changed %s", step.report.Steps[0], write.Key)
+		}
+	}
+
+	if trades, _ := stub.GetState("_opentrades"); trades == nil {
+		t.Fatal("no open trades after migrating")
+	}
+	var index []string
+	indexAsBytes, _ := stub.GetState("_marbleindex")
+	json.Unmarshal(indexAsBytes, &index)
+	if !reflect.DeepEqual(index, []string{"m1", "m2"}) {
+		t.Fatalf("index is %v", index)
+	}
+	var marble marbles.Marble
+	marbleAsBytes, _ := stub.GetState("m1")
+	json.Unmarshal(marbleAsBytes, &marble)
+	if marble.User != "bob" || marble.Color != "red" {
+		t.Fatalf("m1 was not normalized: %+v", marble)
+	}
+	broken, err := marbles.CheckInvariants(stub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range broken{
+		t.Error(msg)
+	}
+	mustInvoke(t, cc, stub, "bob", "set_user", "m1", "alice")						//the owners were registered
+}
@@ -0,0 +1,348 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"strings"
+)
+
+var mintPolicyStr = "_mintpolicy"				//name for the key/value that will store the minting policy
+
+type MintPolicy struct{
+	Minters []string `json:"minters"`				//users allowed to mint, empty lets anyone mint
+	MaxTotal int `json:"max_total"`				//most marbles that may exist at once, 0 for no limit
+	ColorCaps map[string]int `json:"color_caps"`	//color -> most marbles of that color
+	UserMintCap int `json:"user_cap"`				//no more are minted to a user holding this many, 0 for no limit, transfers may go past it
+	UserMintCaps map[string]int `json:"user_caps"`	//user -> limit, overrides user_cap
+	MinSize int `json:"min_size"`					//smallest size that may be minted, 0 for no limit
+	MaxSize int `json:"max_size"`					//largest size that may be minted, 0 for no limit
+}
+
+type Supply struct{
+	Total int `json:"total"`
+	Colors map[string]int `json:"colors"`			//color -> marbles of that color
+	Users map[string]int `json:"users"`			//user -> marbles held
+}
+
+type SupplyCount struct{
+	Count int `json:"count"`
+	Cap int `json:"cap"`							//0 for no cap
+}
+
+type SupplyStats struct{
+	Total SupplyCount `json:"total"`
+	Colors map[string]SupplyCount `json:"colors"`
+	Users map[string]SupplyCount `json:"users"`
+	MinSize int `json:"min_size"`
+	MaxSize int `json:"max_size"`
+	Minters []string `json:"minters"`
+}
+
+// ============================================================================================================================
+// Get Mint Policy - read the minting policy, an empty policy lets anyone mint anything
+// ============================================================================================================================
+func getMintPolicy(stub Stub) (MintPolicy, error) {
+	var policy MintPolicy
+	policyAsBytes, err := stub.GetState(mintPolicyStr)
+	if err != nil {
+		return policy, errors.New("Failed to get mint policy")
+	}
+	json.Unmarshal(policyAsBytes, &policy)										//un stringify it aka JSON.parse()
+	if policy.ColorCaps == nil {
+		policy.ColorCaps = make(map[string]int)
+	}
+	if policy.UserMintCaps == nil {
+		policy.UserMintCaps = make(map[string]int)
+	}
+	return policy, nil
+}
+
+// ============================================================================================================================
+// Put Mint Policy - rewrite the minting policy
+// ============================================================================================================================
+func putMintPolicy(stub Stub, policy MintPolicy) error {
+	jsonAsBytes, _ := json.Marshal(policy)
+	return stub.PutState(mintPolicyStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Get Supply - the live marbles by color and by user, read from the running counters
+// ============================================================================================================================
+func getSupply(stub Stub) (Supply, error) {
+	stats, err := getStats(stub)
+	if err != nil {
+		return Supply{}, err
+	}
+	return Supply{Total: stats.Total, Colors: stats.Colors, Users: stats.Owners}, nil
+}
+
+// ============================================================================================================================
+// User Mint Cap - how many marbles this user may hold and still be minted more, 0 for no limit
+// ============================================================================================================================
+func (policy MintPolicy) userMintCap(user string) int {
+	if limit, ok := policy.UserMintCaps[user]; ok {
+		return limit
+	}
+	return policy.UserMintCap
+}
+
+// ============================================================================================================================
+// Check Mint Policy - errors if minting this marble would break the policy
+// ============================================================================================================================
+func checkMintPolicy(policy MintPolicy, supply Supply, minter string, color string, size int, user string) error {
+	if len(policy.Minters) > 0 {
+		allowed := false
+		for i := range policy.Minters{
+			if policy.Minters[i] == normalizeUser(minter){
+				allowed = true
+			}
+		}
+		if !allowed {
+			return errors.New("'" + minter + "' is not allowed to mint marbles")
+		}
+	}
+	return checkSupplyPolicy(policy, supply, color, size, user)
+}
+
+// ============================================================================================================================
+// Check Supply Policy - errors if one more marble like this would break the size limits or the caps, whoever makes it
+// ============================================================================================================================
+func checkSupplyPolicy(policy MintPolicy, supply Supply, color string, size int, user string) error {
+	err := checkSizePolicy(policy, size)
+	if err != nil {
+		return err
+	}
+	if policy.MaxTotal > 0 && supply.Total >= policy.MaxTotal {
+		return errors.New("Marble supply is capped at " + strconv.Itoa(policy.MaxTotal))
+	}
+	if limit, ok := policy.ColorCaps[color]; ok && supply.Colors[color] >= limit {
+		return errors.New("Supply of " + color + " marbles is capped at " + strconv.Itoa(limit))
+	}
+	if limit := policy.userMintCap(user); limit > 0 && supply.Users[user] >= limit {
+		return errors.New("Minting to " + user + " is capped at " + strconv.Itoa(limit) + " marbles held")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Check Size Policy - errors if a marble of this size is outside the size limits
+// ============================================================================================================================
+func checkSizePolicy(policy MintPolicy, size int) error {
+	if policy.MinSize > 0 && size < policy.MinSize {
+		return errors.New("Size " + strconv.Itoa(size) + " is below the minimum of " + strconv.Itoa(policy.MinSize))
+	}
+	if policy.MaxSize > 0 && size > policy.MaxSize {
+		return errors.New("Size " + strconv.Itoa(size) + " is above the maximum of " + strconv.Itoa(policy.MaxSize))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Set Mint Policy - admin replaces the whole minting policy with a JSON document
+// ============================================================================================================================
+func (t *Chaincode) set_mint_policy(stub Stub, args []string) ([]byte, error) {
+
+	//   0         1
+	// "admin", "{"max_total": 100, "color_caps": {"blue": 10}}"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start set mint policy")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	var policy MintPolicy
+	err := json.Unmarshal([]byte(args[1]), &policy)
+	if err != nil {
+		return nil, errors.New("2nd argument must be a JSON mint policy")
+	}
+	if policy.MaxTotal < 0 || policy.UserMintCap < 0 || policy.MinSize < 0 || policy.MaxSize < 0 {
+		return nil, errors.New("Caps and size limits must not be negative")
+	}
+	for i := range policy.Minters{
+		policy.Minters[i] = normalizeUser(policy.Minters[i])
+	}
+	colorCaps := make(map[string]int)
+	for color, limit := range policy.ColorCaps{
+		colorCaps[strings.ToLower(color)] = limit
+	}
+	policy.ColorCaps = colorCaps
+	userCaps := make(map[string]int)
+	for user, limit := range policy.UserMintCaps{
+		userCaps[normalizeUser(user)] = limit
+	}
+	policy.UserMintCaps = userCaps
+
+	err = putMintPolicy(stub, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set mint policy")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Set Mint Cap - admin edits a single limit of the minting policy
+// ============================================================================================================================
+func (t *Chaincode) set_mint_cap(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0          1         2       3
+	// "admin", "color",  "blue",  "10"
+	// "admin", "total",  "100"
+	// kinds are total, color, user, user_default, min_size and max_size
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 3")
+	}
+
+	fmt.Println("- start set mint limit")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	kind := strings.ToLower(args[1])
+	key := ""
+	valueArg := args[2]
+	if kind == "color" || kind == "user" {
+		if len(args) != 4 {
+			return nil, errors.New("Incorrect number of arguments. Expecting 4")
+		}
+		key = strings.ToLower(args[2])
+		if kind == "user" {
+			key = normalizeUser(args[2])
+		}
+		valueArg = args[3]
+	}
+	value, err := strconv.Atoi(valueArg)
+	if err != nil || value < 0 {
+		return nil, errors.New("Cap must be a non-negative numeric string")
+	}
+
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "total" {
+		policy.MaxTotal = value
+	} else if kind == "color" {
+		policy.ColorCaps[key] = value
+	} else if kind == "user" {
+		policy.UserMintCaps[key] = value
+	} else if kind == "user_default" {
+		policy.UserMintCap = value
+	} else if kind == "min_size" {
+		policy.MinSize = value
+	} else if kind == "max_size" {
+		policy.MaxSize = value
+	} else {
+		return nil, errors.New("Unknown limit " + args[1])
+	}
+	err = putMintPolicy(stub, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set mint limit")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Set Minter - admin allows or stops a user minting marbles
+// ============================================================================================================================
+func (t *Chaincode) set_minter(stub Stub, args []string) ([]byte, error) {
+
+	//   0        1       2
+	// "admin", "bob", "true"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start set minter")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	allow, err := strconv.ParseBool(args[2])
+	if err != nil {
+		return nil, errors.New("3rd argument must be true or false")
+	}
+
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	minter := normalizeUser(args[1])
+	var minters []string
+	for i := range policy.Minters{
+		if policy.Minters[i] != minter{
+			minters = append(minters, policy.Minters[i])
+		}
+	}
+	if allow {
+		minters = append(minters, minter)
+	}
+	policy.Minters = minters
+	err = putMintPolicy(stub, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set minter")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Supply Stats - read the current marble supply against the policy caps
+// ============================================================================================================================
+func (t *Chaincode) supply_stats(stub Stub, args []string) ([]byte, error) {
+	policy, err := getMintPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := SupplyStats{Colors: make(map[string]SupplyCount), Users: make(map[string]SupplyCount)}
+	stats.Total = SupplyCount{Count: supply.Total, Cap: policy.MaxTotal}
+	for color, count := range supply.Colors{
+		stats.Colors[color] = SupplyCount{Count: count, Cap: policy.ColorCaps[color]}
+	}
+	for color, limit := range policy.ColorCaps{										//capped colors nobody has minted yet
+		stats.Colors[color] = SupplyCount{Count: supply.Colors[color], Cap: limit}
+	}
+	for user, count := range supply.Users{
+		stats.Users[user] = SupplyCount{Count: count, Cap: policy.userMintCap(user)}
+	}
+	for user, limit := range policy.UserMintCaps{
+		stats.Users[user] = SupplyCount{Count: supply.Users[user], Cap: limit}
+	}
+	stats.MinSize = policy.MinSize
+	stats.MaxSize = policy.MaxSize
+	stats.Minters = policy.Minters
+
+	jsonAsBytes, _ := json.Marshal(stats)
+	return jsonAsBytes, nil
+}
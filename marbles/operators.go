@@ -0,0 +1,228 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+)
+
+var operatorsStr = "_operators"					//name for the key/value that will store who may act for whom
+
+type Operator struct{
+	Operator string `json:"operator"`			//user allowed to act for the owner
+	Marbles []string `json:"marbles"`			//marbles they may act on, empty for all of them
+	Expires int64 `json:"expires"`				//utc timestamp the approval ends, 0 for never
+}
+
+// ============================================================================================================================
+// Get Operators - read the operator approvals, owner -> operators
+// ============================================================================================================================
+func getOperators(stub Stub) (map[string][]Operator, error) {
+	operatorsAsBytes, err := stub.GetState(operatorsStr)
+	if err != nil {
+		return nil, errors.New("Failed to get operators")
+	}
+	operators := make(map[string][]Operator)
+	json.Unmarshal(operatorsAsBytes, &operators)								//un stringify it aka JSON.parse()
+	return operators, nil
+}
+
+// ============================================================================================================================
+// Put Operators - rewrite the operator approvals
+// ============================================================================================================================
+func putOperators(stub Stub, operators map[string][]Operator) error {
+	jsonAsBytes, _ := json.Marshal(operators)
+	return stub.PutState(operatorsStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Check Caller - errors unless whoever signed the transaction is the owner or an active, unexpired operator for them
+// an empty marble name asks for an operator approved for all of the owner's marbles, which trades need. Expiry is
+// checked against the transaction's timestamp so every peer agrees, without one an expiring approval is refused
+// ============================================================================================================================
+func checkCaller(stub Stub, owner string, marble string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	owner = normalizeUser(owner)
+	if caller == owner {
+		return nil
+	}
+	operators, err := getOperators(stub)
+	if err != nil {
+		return err
+	}
+	for _, op := range operators[owner]{
+		if op.Operator != caller {
+			continue
+		}
+		_, err = checkActiveUser(stub, op.Operator)								//a suspended operator acts for nobody
+		if err != nil {
+			return err
+		}
+		if op.Expires != 0 {
+			now, err := txTimestamp(stub)
+			if err != nil {
+				return errors.New("Cannot tell if " + caller + "'s approval to act for " + owner + " has expired without a transaction timestamp")
+			}
+			if op.Expires < now {
+				return errors.New(caller + "'s approval to act for " + owner + " has expired")
+			}
+		}
+		if len(op.Marbles) == 0 || (marble != "" && contains(op.Marbles, marble)) {
+			return nil
+		}
+		if marble == "" {
+			return errors.New(caller + " may only act for " + owner + " on specific marbles")
+		}
+		return errors.New(caller + " may not act for " + owner + " on " + marble)
+	}
+	return errors.New(caller + " may not act for " + owner)
+}
+
+// ============================================================================================================================
+// Approve Operator - owner lets another user act for them, on every marble or just the ones listed
+// ============================================================================================================================
+func (t *Chaincode) approve_operator(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0        1          2             3...
+	// "bob", "desk", "1460000000000", *"name"...*
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 3")
+	}
+
+	fmt.Println("- start approve operator")
+	owner, err := checkActiveUser(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkSigner(stub, owner)												//only the owner hands out approvals
+	if err != nil {
+		return nil, err
+	}
+	operator, err := checkActiveUser(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if owner == operator {
+		return nil, errors.New("Cannot approve yourself as an operator")
+	}
+	expires, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || expires < 0 {
+		return nil, errors.New("3rd argument must be a timestamp or 0 for never")
+	}
+	approval := Operator{Operator: operator, Expires: expires}
+	for i:=3; i < len(args); i++ {
+		_, err = getOwnedMarble(stub, args[i], owner)
+		if err != nil {
+			return nil, err
+		}
+		approval.Marbles = append(approval.Marbles, args[i])
+	}
+
+	operators, err := getOperators(stub)
+	if err != nil {
+		return nil, err
+	}
+	var kept []Operator
+	for _, op := range operators[owner]{										//replaces any earlier approval
+		if op.Operator != operator {
+			kept = append(kept, op)
+		}
+	}
+	operators[owner] = append(kept, approval)
+	err = putOperators(stub, operators)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end approve operator")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Revoke Operator - owner stops another user acting for them
+// ============================================================================================================================
+func (t *Chaincode) revoke_operator(stub Stub, args []string) ([]byte, error) {
+
+	//   0       1
+	// "bob", "desk"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start revoke operator")
+	owner := normalizeUser(args[0])
+	operator := normalizeUser(args[1])
+	err := checkSigner(stub, owner)
+	if err != nil {
+		return nil, err
+	}
+	operators, err := getOperators(stub)
+	if err != nil {
+		return nil, err
+	}
+	var kept []Operator
+	for _, op := range operators[owner]{
+		if op.Operator != operator {
+			kept = append(kept, op)
+		}
+	}
+	if len(kept) == len(operators[owner]) {
+		return nil, errors.New(operator + " is not an operator for " + owner)
+	}
+	if len(kept) == 0 {
+		delete(operators, owner)
+	} else {
+		operators[owner] = kept
+	}
+	err = putOperators(stub, operators)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end revoke operator")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Operators - read who may act for an owner
+// ============================================================================================================================
+func (t *Chaincode) get_operators(stub Stub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the owner to query")
+	}
+
+	operators, err := getOperators(stub)
+	if err != nil {
+		return nil, err
+	}
+	list := operators[normalizeUser(args[0])]
+	if list == nil {
+		list = []Operator{}
+	}
+	jsonAsBytes, _ := json.Marshal(list)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,190 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"strconv"
+	"encoding/json"
+	"strings"
+)
+
+var receiptPrefix = "_receipt_"					//prefix for the key/value that stores each completed trade
+var userTradesPrefix = "_usertrades_"			//prefix for the key/value that lists the completed trades of a user
+var marbleTradesPrefix = "_marbletrades_"		//prefix for the key/value that lists the completed trades of a marble
+
+type Receipt struct{
+	ID int64 `json:"id"`						//the trade ID, or a fresh one for each fill of a partially filled trade
+	TradeID int64 `json:"trade_id"`				//timestamp ID the trade had while open
+	Opener string `json:"opener"`
+	Closer string `json:"closer"`
+	ToOpener []Marble `json:"to_opener"`		//marbles the closer gave, as they were before the trade
+	ToCloser []Marble `json:"to_closer"`		//marbles the opener gave, as they were before the trade
+	Price int `json:"price"`					//tokens the opener paid the closer
+	Fee int `json:"fee"`						//tokens charged to settle the trade
+	Timestamp int64 `json:"timestamp"`			//utc timestamp of settlement
+}
+
+// ============================================================================================================================
+// Get Receipt - read the receipt of a completed trade
+// ============================================================================================================================
+func getReceipt(stub Stub, id int64) (Receipt, error) {
+	var receipt Receipt
+	receiptAsBytes, err := stub.GetState(receiptPrefix + strconv.FormatInt(id, 10))
+	if err != nil {
+		return receipt, errors.New("Failed to get receipt")
+	}
+	if receiptAsBytes == nil {
+		return receipt, errors.New("No receipt for trade " + strconv.FormatInt(id, 10))
+	}
+	json.Unmarshal(receiptAsBytes, &receipt)									//un stringify it aka JSON.parse()
+	return receipt, nil
+}
+
+// ============================================================================================================================
+// Has Receipt - true if a trade with this ID already completed, trade IDs are never reused
+// ============================================================================================================================
+func hasReceipt(stub Stub, id int64) bool {
+	receiptAsBytes, err := stub.GetState(receiptPrefix + strconv.FormatInt(id, 10))
+	return err == nil && receiptAsBytes != nil
+}
+
+// ============================================================================================================================
+// New Receipt ID - an ID no receipt or open trade has, for fills of a trade that stays open
+// ============================================================================================================================
+func newReceiptID(stub Stub, trades AllTrades) int64 {
+	id := makeTimestamp(stub)
+	for taken := true; taken; {
+		taken = hasReceipt(stub, id)
+		for i := range trades.OpenTrades{
+			if trades.OpenTrades[i].Timestamp == id {
+				taken = true
+			}
+		}
+		if taken {
+			id++
+		}
+	}
+	return id
+}
+
+// ============================================================================================================================
+// Append Trade ID - add a completed trade to one of the history lists
+// ============================================================================================================================
+func appendTradeID(stub Stub, key string, id int64) error {
+	idsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get trade list " + key)
+	}
+	var ids []int64
+	json.Unmarshal(idsAsBytes, &ids)											//un stringify it aka JSON.parse()
+	ids = append(ids, id)
+	jsonAsBytes, _ := json.Marshal(ids)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Record Receipt - store the receipt of a completed trade and list it under both users and every marble exchanged
+// ============================================================================================================================
+func recordReceipt(stub Stub, receipt Receipt) error {
+	jsonAsBytes, _ := json.Marshal(receipt)
+	err := stub.PutState(receiptPrefix + strconv.FormatInt(receipt.ID, 10), jsonAsBytes)
+	if err != nil {
+		return err
+	}
+
+	err = appendTradeID(stub, userTradesPrefix + receipt.Opener, receipt.ID)
+	if err != nil {
+		return err
+	}
+	err = appendTradeID(stub, userTradesPrefix + receipt.Closer, receipt.ID)
+	if err != nil {
+		return err
+	}
+	marbles := append(append([]Marble{}, receipt.ToOpener...), receipt.ToCloser...)
+	for i := range marbles{
+		err = appendTradeID(stub, marbleTradesPrefix + marbles[i].Name, receipt.ID)
+		if err != nil {
+			return err
+		}
+	}
+	setEvent(stub, "trade_completed", receipt)
+	return nil
+}
+
+// ============================================================================================================================
+// Get Receipt - read the receipt of a completed trade
+// ============================================================================================================================
+func (t *Chaincode) get_receipt(stub Stub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the trade to query")
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("1st argument must be a numeric string")
+	}
+	receipt, err := getReceipt(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(receipt)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Trade History - read the receipts of every completed trade of a user or a marble, oldest first
+// ============================================================================================================================
+func (t *Chaincode) trade_history(stub Stub, args []string) ([]byte, error) {
+
+	//   0        1
+	// "user", "bob"
+	// "marble", "asdf"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	key := ""
+	kind := strings.ToLower(args[0])
+	if kind == "user" {
+		key = userTradesPrefix + normalizeUser(args[1])
+	} else if kind == "marble" {
+		key = marbleTradesPrefix + args[1]
+	} else {
+		return nil, errors.New("1st argument must be user or marble")
+	}
+	idsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get trade list " + key)
+	}
+	var ids []int64
+	json.Unmarshal(idsAsBytes, &ids)											//un stringify it aka JSON.parse()
+
+	receipts := []Receipt{}
+	for i := range ids{
+		receipt, err := getReceipt(stub, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	jsonAsBytes, _ := json.Marshal(receipts)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,174 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+func getReceipt(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, id int64) marbles.Receipt {
+	t.Helper()
+	res, err, _ := apply(cc, stub, "alice", "query", "get_receipt", []string{strconv.FormatInt(id, 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var receipt marbles.Receipt
+	json.Unmarshal(res, &receipt)
+	return receipt
+}
+
+// checkHistory fails unless the trade history of a user or marble is these receipt IDs, oldest first
+func checkHistory(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, kind string, key string, ids ...int64) {
+	t.Helper()
+	res, err, _ := apply(cc, stub, "alice", "query", "trade_history", []string{kind, key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var receipts []marbles.Receipt
+	json.Unmarshal(res, &receipts)
+	got := []int64{}
+	for _, receipt := range receipts{
+		got = append(got, receipt.ID)
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("%s %s history is %v, want %v", kind, key, got, ids)
+	}
+	for i := range ids{
+		if got[i] != ids[i] {
+			t.Fatalf("%s %s history is %v, want %v", kind, key, got, ids)
+		}
+	}
+}
+
+func TestReceiptOfATrade(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "2", "opener")
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m2", "blue", "16", "bob")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16", "5")
+	id := openTrades(t, cc, stub)[0].Timestamp
+	mustInvoke(t, cc, stub, "bob", "perform_trade", strconv.FormatInt(id, 10), "m2", "0")
+	settled := stub.Timestamp
+
+	receipt := getReceipt(t, cc, stub, id)
+	if receipt.ID != id || receipt.TradeID != id || receipt.Opener != "alice" || receipt.Closer != "bob" || receipt.Price != 5 || receipt.Fee != 2 || receipt.Timestamp != settled {
+		t.Fatalf("receipt %+v", receipt)
+	}
+	if len(receipt.ToOpener) != 1 || receipt.ToOpener[0].Name != "m2" || receipt.ToOpener[0].User != "bob" {
+		t.Fatalf("to opener %+v, want m2 as bob had it", receipt.ToOpener)
+	}
+	if len(receipt.ToCloser) != 1 || receipt.ToCloser[0].Name != "m1" || receipt.ToCloser[0].User != "alice" {
+		t.Fatalf("to closer %+v, want m1 as alice had it", receipt.ToCloser)
+	}
+	for _, key := range [][]string{{"user", "alice"}, {"user", "bob"}, {"marble", "m1"}, {"marble", "m2"}}{
+		checkHistory(t, cc, stub, key[0], key[1], id)
+	}
+	checkHistory(t, cc, stub, "user", "carol")
+	if _, err, _ := apply(cc, stub, "alice", "query", "get_receipt", []string{strconv.FormatInt(id + 1, 10)}); err == nil {
+		t.Fatal("read a receipt for a trade that never completed")
+	}
+}
+
+func TestReceiptsOfPartialFills(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	policyAsBytes, _ := json.Marshal(marbles.TradePolicy{AllowPartial: true})
+	mustInvoke(t, cc, stub, "admin", "set_trade_policy", "admin", string(policyAsBytes))
+	for _, call := range [][]string{
+		{"alice", "m1", "red", "16"}, {"alice", "m2", "green", "16"},
+		{"bob", "m3", "blue", "16"}, {"carol", "m4", "blue", "16"},
+	}{
+		mustInvoke(t, cc, stub, call[0], "init_marble", call[1], call[2], call[3], call[0])
+	}
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16", "green", "16")
+	id := openTrades(t, cc, stub)[0].Timestamp
+	mustInvoke(t, cc, stub, "bob", "perform_trade", strconv.FormatInt(id, 10), "m3", "0")	//gives up m1, m2 stays offered
+	mustInvoke(t, cc, stub, "carol", "perform_trade", strconv.FormatInt(id, 10), "m4", "0")
+
+	history := func(user string) []marbles.Receipt {
+		res, _, _ := apply(cc, stub, user, "query", "trade_history", []string{"user", user})
+		var receipts []marbles.Receipt
+		json.Unmarshal(res, &receipts)
+		return receipts
+	}
+	fills := history("alice")
+	if len(fills) != 2 || fills[0].ID == fills[1].ID || fills[0].ID == id {
+		t.Fatalf("alice's fills %+v, want two receipts, the first with a fresh ID", fills)
+	}
+	for _, fill := range fills{
+		if fill.TradeID != id {
+			t.Fatalf("fill %d is for trade %d, want %d", fill.ID, fill.TradeID, id)
+		}
+	}
+	first, second := fills[0].ID, fills[1].ID
+	checkHistory(t, cc, stub, "user", "bob", first)
+	checkHistory(t, cc, stub, "user", "carol", second)
+	checkHistory(t, cc, stub, "marble", "m1", first)
+	checkHistory(t, cc, stub, "marble", "m3", first)
+	checkHistory(t, cc, stub, "marble", "m2", second)
+	checkHistory(t, cc, stub, "marble", "m4", second)
+	if receipt := getReceipt(t, cc, stub, first); receipt.Closer != "bob" || receipt.ToCloser[0].Name != "m1" {
+		t.Fatalf("first fill %+v", receipt)
+	}
+}
+
+func TestReusedTradeIDGetsFreshReceipt(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	for _, call := range [][]string{
+		{"alice", "m1", "red", "16"}, {"alice", "m2", "red", "16"},
+		{"bob", "m3", "blue", "16"}, {"bob", "m4", "blue", "16"},
+	}{
+		mustInvoke(t, cc, stub, call[0], "init_marble", call[1], call[2], call[3], call[0])
+	}
+	opened := stub.Timestamp
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16")
+	id := openTrades(t, cc, stub)[0].Timestamp
+	mustInvoke(t, cc, stub, "bob", "perform_trade", strconv.FormatInt(id, 10), "m3", "0")
+
+	stub.Timestamp = opened														//a second trade opened at the same time skips the ID
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16")
+	trades := openTrades(t, cc, stub)
+	if trades[0].Timestamp != id + 1 {
+		t.Fatalf("second trade has ID %d, want %d", trades[0].Timestamp, id + 1)
+	}
+	trades[0].Timestamp = id														//ledgers from before that rule can hold the ID again
+	tradesAsBytes, _ := json.Marshal(marbles.AllTrades{OpenTrades: trades})
+	stub.PutState("_opentrades", tradesAsBytes)
+	stub.Timestamp += 10000
+	mustInvoke(t, cc, stub, "bob", "perform_trade", strconv.FormatInt(id, 10), "m4", "0")
+
+	if receipt := getReceipt(t, cc, stub, id); receipt.ToOpener[0].Name != "m3" {
+		t.Fatalf("the first receipt was overwritten: %+v", receipt)
+	}
+	res, _, _ := apply(cc, stub, "alice", "query", "trade_history", []string{"marble", "m4"})
+	var receipts []marbles.Receipt
+	json.Unmarshal(res, &receipts)
+	if len(receipts) != 1 || receipts[0].ID == id || receipts[0].TradeID != id {
+		t.Fatalf("m4's trade %+v, want a fresh receipt ID for trade %d", receipts, id)
+	}
+	checkHistory(t, cc, stub, "user", "alice", id, receipts[0].ID)
+}
@@ -0,0 +1,194 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+	"sort"
+)
+
+// EventStub is a stub that can also take chaincode events, stubs without it just don't get any
+type EventStub interface {
+	SetEvent(name string, payload []byte) error
+}
+
+type Event struct{
+	Name string `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type TransferEvent struct{
+	Name string `json:"name"`
+	From string `json:"from"`
+	To string `json:"to"`
+}
+
+type HistoryEvent struct{
+	Name string `json:"name"`
+	MarbleEvent
+}
+
+type StateWrite struct{
+	Key string `json:"key"`
+	Before string `json:"before"`				//value in the ledger now, empty if there is none
+	After string `json:"after"`					//value the invoke would leave, empty if deleted
+	Deleted bool `json:"deleted"`
+}
+
+type SimulateResult struct{
+	Function string `json:"function"`
+	Args []string `json:"args"`
+	Result string `json:"result"`				//what the invoke returned
+	Error string `json:"error"`					//why the invoke would fail, nothing is written then
+	Writes []StateWrite `json:"writes"`			//by key
+	Events []Event `json:"events"`				//in the order they were set
+}
+
+// ============================================================================================================================
+// Set Event - hand an event to the stub if it takes them
+// ============================================================================================================================
+func setEvent(stub Stub, name string, payload interface{}) {
+	eventStub, ok := stub.(EventStub)
+	if !ok {
+		return
+	}
+	jsonAsBytes, _ := json.Marshal(payload)
+	eventStub.SetEvent(name, jsonAsBytes)
+}
+
+// overlayStub keeps the writes of an invoke to itself and reads through to the ledger for everything else
+type overlayStub struct{
+	base Stub
+	writes map[string][]byte
+	deleted map[string]bool
+	events []Event
+}
+
+func newOverlayStub(base Stub) *overlayStub {
+	return &overlayStub{base: base, writes: make(map[string][]byte), deleted: make(map[string]bool)}
+}
+
+func (o *overlayStub) GetState(key string) ([]byte, error) {
+	if o.deleted[key] {
+		return nil, nil
+	}
+	if value, ok := o.writes[key]; ok {
+		return value, nil
+	}
+	return o.base.GetState(key)
+}
+
+func (o *overlayStub) PutState(key string, value []byte) error {
+	o.writes[key] = append([]byte{}, value...)
+	delete(o.deleted, key)
+	return nil
+}
+
+func (o *overlayStub) DelState(key string) error {
+	delete(o.writes, key)
+	o.deleted[key] = true
+	return nil
+}
+
+func (o *overlayStub) SetEvent(name string, payload []byte) error {
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(string(payload))
+	}
+	o.events = append(o.events, Event{Name: name, Payload: payload})
+	return nil
+}
+
+func (o *overlayStub) GetCaller() (string, error) {
+	return o.base.GetCaller()
+}
+
+func (o *overlayStub) TxTimestamp() (int64, error) {
+	return txTimestamp(o.base)
+}
+
+// ============================================================================================================================
+// Write Set - the keys the invoke touched, with the ledger's value next to the new one
+// ============================================================================================================================
+func (o *overlayStub) writeSet() ([]StateWrite, error) {
+	var keys []string
+	for key := range o.writes{
+		keys = append(keys, key)
+	}
+	for key := range o.deleted{
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writes := []StateWrite{}
+	for _, key := range keys{
+		before, err := o.base.GetState(key)
+		if err != nil {
+			return nil, errors.New("Failed to get state for " + key)
+		}
+		if o.deleted[key] {
+			if before == nil {
+				continue														//deleting nothing changes nothing
+			}
+			writes = append(writes, StateWrite{Key: key, Before: string(before), Deleted: true})
+		} else if string(before) != string(o.writes[key]) {
+			writes = append(writes, StateWrite{Key: key, Before: string(before), After: string(o.writes[key])})
+		}
+	}
+	return writes, nil
+}
+
+// ============================================================================================================================
+// Simulate - run an invoke against an overlay of the ledger and read what it would write, nothing is committed
+// ============================================================================================================================
+func (t *Chaincode) simulate(stub Stub, args []string) ([]byte, error) {
+
+	//     0           1      2
+	// "set_user", "marble", "bob"
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the function to simulate")
+	}
+
+	fmt.Println("- start simulate " + args[0])
+	fn, ok := t.functions[args[0]]
+	if !ok || fn.Kind != "invoke" {
+		return nil, errors.New(args[0] + " is not an invoke function")
+	}
+
+	overlay := newOverlayStub(stub)
+	res, err := t.Invoke(overlay, args[0], args[1:])
+	result := SimulateResult{Function: args[0], Args: args[1:], Result: string(res), Writes: []StateWrite{}, Events: []Event{}}
+	if err != nil {
+		result.Error = err.Error()											//a failed invoke commits nothing
+	} else {
+		result.Writes, err = overlay.writeSet()
+		if err != nil {
+			return nil, err
+		}
+		if overlay.events != nil {
+			result.Events = overlay.events
+		}
+	}
+
+	fmt.Println("- end simulate")
+	jsonAsBytes, _ := json.Marshal(result)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,87 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// simulateLedger has alice offering m1 or m2 in one trade and only m1 in another, bob holds the blue marble both want
+func simulateLedger(t *testing.T, cc *marbles.Chaincode) (*memstub.Stub, string) {
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m1", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m2", "green", "16", "alice")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m3", "blue", "16", "bob")
+	mustInvoke(t, cc, stub, "carol", "init_marble", "m4", "amber", "5", "carol")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16", "green", "16")
+	id := strconv.FormatInt(stub.Timestamp, 10)
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "5", "red", "16")
+	mustInvoke(t, cc, stub, "carol", "open_trade", "carol", "red", "16", "amber", "5")
+	return stub, id
+}
+
+func TestSimulateMatchesInvoke(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, test := range []struct{
+		caller string
+		call []string
+		trades int												//open trades left after the invoke
+	}{
+		{"alice", []string{"set_user", "m1", "bob"}, 2},			//drops an option from the first trade and all of the second
+		{"alice", []string{"burn_marble", "m2", "alice"}, 3},		//drops an option from the first trade
+		{"bob", []string{"perform_trade", "", "m3", "0"}, 1},
+		{"carol", []string{"transfer_tokens", "carol", "dave", "5"}, 3},
+		{"bob", []string{"set_user", "m1", "bob"}, 3},			//fails, bob does not hold m1
+	}{
+		stub, id := simulateLedger(t, cc)
+		call := append([]string{}, test.call...)
+		if call[0] == "perform_trade" {
+			call[1] = id
+		}
+		before := stub.Snapshot()
+		res, err, _ := apply(cc, stub, test.caller, "query", "simulate", call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result marbles.SimulateResult
+		json.Unmarshal(res, &result)
+		for _, write := range memstub.Diff(before, stub.Snapshot()){
+			t.Errorf("simulating %s wrote %s", call[0], write.Key)
+		}
+
+		stub.Timestamp -= 1000														//the same transaction time as the simulation
+		_, err, _ = apply(cc, stub, test.caller, "invoke", call[0], call[1:])
+		if (err == nil) != (result.Error == "") {
+			t.Fatalf("%s failed with %v, the simulation with %q", call[0], err, result.Error)
+		}
+		if writes := memstub.Diff(before, stub.Snapshot()); !reflect.DeepEqual(writes, result.Writes) {
+			t.Errorf("%s wrote %+v, the simulation %+v", call[0], writes, result.Writes)
+		}
+		if trades := openTrades(t, cc, stub); len(trades) != test.trades {
+			t.Errorf("%s left %d open trades, want %d", call[0], len(trades), test.trades)
+		}
+	}
+}
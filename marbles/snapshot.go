@@ -0,0 +1,372 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+var snapshotVersion = 1							//bump when the snapshot layout changes
+
+type SnapshotConfig struct{
+	FeeSchedule FeeSchedule `json:"fee_schedule"`
+	MintPolicy MintPolicy `json:"mint_policy"`
+	AttributeSchema AttributeSchema `json:"attribute_schema"`
+	ApprovalRules ApprovalRules `json:"approval_rules"`
+	Operators map[string][]Operator `json:"operators"`
+	TradePolicy *TradePolicy `json:"trade_policy,omitempty"`	//left out while the preset's policy applies
+	Admins []string `json:"admins"`				//exported for reference, never imported
+}
+
+type Snapshot struct{
+	Version int `json:"version"`
+	Marbles []Marble `json:"marbles"`
+	OpenTrades []AnOpenTrade `json:"open_trades"`
+	Users map[string]User `json:"users"`
+	Balances map[string]int `json:"balances"`
+	Config SnapshotConfig `json:"config"`
+}
+
+type ImportReport struct{
+	Mode string `json:"mode"`
+	Marbles int `json:"marbles"`				//marbles written
+	OpenTrades int `json:"open_trades"`			//open trades written
+	Users int `json:"users"`					//users written
+	DroppedPending int `json:"dropped_pending"`	//pending transfers a replace threw away, their marbles are gone
+	Conflicts []string `json:"conflicts"`		//things skipped because state already had something different
+}
+
+// ============================================================================================================================
+// Export State - read every marble in the index, the open trades, users, balances and config as one versioned document
+// ============================================================================================================================
+func (t *Chaincode) export_state(stub Stub, args []string) ([]byte, error) {
+	var err error
+	snapshot := Snapshot{Version: snapshotVersion, Marbles: []Marble{}}
+
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	for i := range marbleIndex{
+		marble, err := getMarble(stub, marbleIndex[i])
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Marbles = append(snapshot.Marbles, marble)
+	}
+
+	tradesAsBytes, err := stub.GetState(openTradesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get opentrades")
+	}
+	var trades AllTrades
+	json.Unmarshal(tradesAsBytes, &trades)										//un stringify it aka JSON.parse()
+	snapshot.OpenTrades = trades.OpenTrades
+
+	if snapshot.Users, err = getUsers(stub); err != nil {
+		return nil, err
+	}
+	if snapshot.Balances, err = getBalances(stub); err != nil {
+		return nil, err
+	}
+	if snapshot.Config.FeeSchedule, err = getFeeSchedule(stub); err != nil {
+		return nil, err
+	}
+	if snapshot.Config.MintPolicy, err = getMintPolicy(stub); err != nil {
+		return nil, err
+	}
+	if snapshot.Config.AttributeSchema, err = getAttributeSchema(stub); err != nil {
+		return nil, err
+	}
+	rulesAsBytes, err := stub.GetState(approvalRulesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get approval rules")
+	}
+	json.Unmarshal(rulesAsBytes, &snapshot.Config.ApprovalRules)				//un stringify it aka JSON.parse()
+	if snapshot.Config.Operators, err = getOperators(stub); err != nil {
+		return nil, err
+	}
+	policyAsBytes, err := stub.GetState(tradePolicyStr)
+	if err != nil {
+		return nil, errors.New("Failed to get trade policy")
+	}
+	if policyAsBytes != nil {
+		snapshot.Config.TradePolicy = &TradePolicy{}
+		json.Unmarshal(policyAsBytes, snapshot.Config.TradePolicy)				//un stringify it aka JSON.parse()
+	}
+	if snapshot.Config.Admins, err = getAdmins(stub); err != nil {
+		return nil, err
+	}
+
+	jsonAsBytes, _ := json.Marshal(snapshot)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Validate Snapshot - errors if the document is not something we can load, nothing is written until this passes
+// ============================================================================================================================
+func validateSnapshot(snapshot Snapshot, users map[string]User, schema AttributeSchema) error {
+	if snapshot.Version < 1 || snapshot.Version > snapshotVersion {
+		return errors.New("Unsupported snapshot version " + strconv.Itoa(snapshot.Version))
+	}
+	seen := make(map[string]bool)
+	for i, marble := range snapshot.Marbles{
+		at := "marble " + strconv.Itoa(i) + " (" + marble.Name + ")"
+		if len(marble.Name) <= 0 || strings.HasPrefix(marble.Name, "_") {
+			return errors.New(at + " must have a name not starting with _")
+		}
+		if seen[marble.Name] {
+			return errors.New(at + " is listed twice")
+		}
+		seen[marble.Name] = true
+		if len(marble.Color) <= 0 || marble.Size <= 0 {
+			return errors.New(at + " must have a color and a positive size")
+		}
+		if marble.Status != "" {
+			return errors.New(at + " is " + marble.Status + ", only live marbles can be imported")
+		}
+		if _, ok := users[normalizeUser(marble.User)]; !ok {
+			return errors.New(at + " is owned by unknown user " + marble.User)
+		}
+		err := validateAttributes(schema, marble.Attributes)
+		if err != nil {
+			return errors.New(at + ": " + err.Error())
+		}
+	}
+	for i, trade := range snapshot.OpenTrades{
+		if _, ok := users[normalizeUser(trade.User)]; !ok {
+			return errors.New("open trade " + strconv.Itoa(i) + " is opened by unknown user " + trade.User)
+		}
+		if len(trade.Willing) == 0 && trade.Price <= 0 {
+			return errors.New("open trade " + strconv.Itoa(i) + " offers nothing")
+		}
+	}
+	for user, balance := range snapshot.Balances{
+		if balance < 0 {
+			return errors.New("balance of " + user + " is negative")
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Import State - admin loads a snapshot, merge keeps what state already has, replace swaps it out
+// ============================================================================================================================
+func (t *Chaincode) import_state(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0         1          2
+	// "admin", "merge", "{"version": 1, ...}"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start import state")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	mode := strings.ToLower(args[1])
+	if mode != "merge" && mode != "replace" {
+		return nil, errors.New("2nd argument must be merge or replace")
+	}
+	var snapshot Snapshot
+	err = json.Unmarshal([]byte(args[2]), &snapshot)
+	if err != nil {
+		return nil, errors.New("3rd argument must be a JSON snapshot")
+	}
+	report := ImportReport{Mode: mode, Conflicts: []string{}}
+
+	//work out what the users, balances and schema will be once loaded
+	users := make(map[string]User)
+	balances := make(map[string]int)
+	schema := snapshot.Config.AttributeSchema
+	if mode == "merge" {
+		if users, err = getUsers(stub); err != nil {
+			return nil, err
+		}
+		if balances, err = getBalances(stub); err != nil {
+			return nil, err
+		}
+		if schema, err = getAttributeSchema(stub); err != nil {
+			return nil, err
+		}
+	}
+	for id, profile := range snapshot.Users{
+		id = normalizeUser(id)
+		profile.ID = id
+		if existing, ok := users[id]; ok && !reflect.DeepEqual(existing, profile) {
+			report.Conflicts = append(report.Conflicts, "user " + id + " already exists, kept the existing record")
+			continue
+		} else if ok {
+			continue
+		}
+		users[id] = profile
+		report.Users++
+	}
+	for user, balance := range snapshot.Balances{
+		user = normalizeUser(user)
+		if existing, ok := balances[user]; ok && existing != balance {
+			report.Conflicts = append(report.Conflicts, "balance of " + user + " already set, kept " + strconv.Itoa(existing))
+			continue
+		}
+		balances[user] = balance
+	}
+	err = validateSnapshot(snapshot, users, schema)
+	if err != nil {
+		return nil, err
+	}
+	for _, marble := range snapshot.Marbles{										//names are never reused, not even by a replace
+		existing, err := getMarble(stub, marble.Name)
+		if err == nil && existing.Status != "" {
+			return nil, errors.New("Marble " + marble.Name + " was " + existing.Status + ", its name cannot be imported")
+		}
+	}
+
+	//clear out or load the existing marbles and trades
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	var trades AllTrades
+	if mode == "replace" {
+		for i := range marbleIndex{
+			err = deleteMarble(stub, marbleIndex[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		marbleIndex = []string{}
+		pending, err := getPendingTransfers(stub)
+		if err != nil {
+			return nil, err
+		}
+		report.DroppedPending = len(pending.Transfers)
+		err = putPendingTransfers(stub, PendingTransfers{})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		tradesAsBytes, err := stub.GetState(openTradesStr)
+		if err != nil {
+			return nil, errors.New("Failed to get opentrades")
+		}
+		json.Unmarshal(tradesAsBytes, &trades)									//un stringify it aka JSON.parse()
+	}
+
+	for _, marble := range snapshot.Marbles{
+		marble.Color = strings.ToLower(marble.Color)
+		marble.User = normalizeUser(marble.User)
+		marble.Version = schemaVersion
+		if mode == "merge" {
+			existing, err := getMarble(stub, marble.Name)
+			if err == nil && reflect.DeepEqual(existing, marble) {
+				continue														//already have it
+			} else if err == nil {
+				report.Conflicts = append(report.Conflicts, "marble " + marble.Name + " already exists, kept the existing marble")
+				continue
+			}
+		}
+		err = putMarble(stub, marble)
+		if err != nil {
+			return nil, err
+		}
+		marbleIndex = append(marbleIndex, marble.Name)
+		report.Marbles++
+	}
+	err = putMarbleIndex(stub, marbleIndex)										//rebuild the index
+	if err != nil {
+		return nil, err
+	}
+
+	for _, trade := range snapshot.OpenTrades{
+		trade.User = normalizeUser(trade.User)
+		duplicate := false
+		same := false
+		for i := range trades.OpenTrades{
+			if trades.OpenTrades[i].Timestamp == trade.Timestamp{
+				duplicate = true
+				same = reflect.DeepEqual(trades.OpenTrades[i], trade)
+			}
+		}
+		if same {
+			continue															//already have it
+		} else if duplicate {
+			report.Conflicts = append(report.Conflicts, "open trade " + strconv.FormatInt(trade.Timestamp, 10) + " already exists, kept the existing trade")
+			continue
+		}
+		trades.OpenTrades = append(trades.OpenTrades, trade)
+		report.OpenTrades++
+	}
+	err = putTrades(stub, trades)												//rewrite open orders
+	if err != nil {
+		return nil, err
+	}
+
+	err = putUsers(stub, users)
+	if err != nil {
+		return nil, err
+	}
+	err = putBalances(stub, balances)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "replace" {														//config only comes along on a replace, admins never do
+		config := snapshot.Config
+		jsonAsBytes, _ := json.Marshal(config.FeeSchedule)
+		if err = stub.PutState(feeScheduleStr, jsonAsBytes); err != nil {
+			return nil, err
+		}
+		if err = putMintPolicy(stub, config.MintPolicy); err != nil {
+			return nil, err
+		}
+		jsonAsBytes, _ = json.Marshal(config.AttributeSchema)
+		if err = stub.PutState(attributeSchemaStr, jsonAsBytes); err != nil {
+			return nil, err
+		}
+		jsonAsBytes, _ = json.Marshal(config.ApprovalRules)
+		if err = stub.PutState(approvalRulesStr, jsonAsBytes); err != nil {
+			return nil, err
+		}
+		if config.Operators == nil {
+			config.Operators = make(map[string][]Operator)
+		}
+		if err = putOperators(stub, config.Operators); err != nil {
+			return nil, err
+		}
+		if config.TradePolicy != nil {
+			jsonAsBytes, _ = json.Marshal(config.TradePolicy)
+			err = stub.PutState(tradePolicyStr, jsonAsBytes)
+		} else {
+			err = stub.DelState(tradePolicyStr)									//back to the preset's policy
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("- end import state")
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,150 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// snapshotLedger is the trading ledger with some config set, so every part of a snapshot has something in it
+func snapshotLedger(t *testing.T, cc *marbles.Chaincode) *memstub.Stub {
+	stub := tradingLedger(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "flat", "2", "both")
+	mustInvoke(t, cc, stub, "admin", "set_mint_policy", "admin", `{"max_total": 50, "color_caps": {"red": 10}}`)
+	mustInvoke(t, cc, stub, "admin", "set_approval_rules", "admin", `{"rules": [{"min_size": 100, "approvers": ["dave"], "required": 1}]}`)
+	mustInvoke(t, cc, stub, "alice", "approve_operator", "alice", "carol", "0")
+	return stub
+}
+
+func exportState(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub) string {
+	t.Helper()
+	res, err, _ := apply(cc, stub, "admin", "query", "export_state", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(res)
+}
+
+func importState(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, mode string, snapshot string) marbles.ImportReport {
+	t.Helper()
+	var report marbles.ImportReport
+	json.Unmarshal(mustInvoke(t, cc, stub, "admin", "import_state", "admin", mode, snapshot), &report)
+	return report
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	exported := exportState(t, cc, snapshotLedger(t, cc))
+
+	stub := setup(t, cc)
+	report := importState(t, cc, stub, "replace", exported)
+	if report.Marbles != 3 || report.OpenTrades != 2 || len(report.Conflicts) != 0 {
+		t.Fatalf("import reported %+v", report)
+	}
+	if again := exportState(t, cc, stub); again != exported {
+		t.Fatalf("exported after import:\n%s\nwant:\n%s", again, exported)
+	}
+	broken, err := marbles.CheckInvariants(stub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range broken{
+		t.Error(msg)
+	}
+
+	before := stub.Snapshot()
+	report = importState(t, cc, stub, "merge", exported)							//everything is already there
+	if report.Marbles != 0 || report.OpenTrades != 0 || report.Users != 0 || len(report.Conflicts) != 0 {
+		t.Fatalf("merging the same snapshot reported %+v", report)
+	}
+	for _, write := range memstub.Diff(before, stub.Snapshot()){
+		t.Errorf("merging the same snapshot changed %s", write.Key)
+	}
+}
+
+func TestImportMergeKeepsExisting(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	exported := exportState(t, cc, snapshotLedger(t, cc))
+
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m1", "purple", "5", "bob")		//same name, different marble
+	mustInvoke(t, cc, stub, "admin", "mint_tokens", "admin", "alice", "1")
+	mustInvoke(t, cc, stub, "admin", "set_fee_schedule", "admin", "none", "0", "opener")
+	report := importState(t, cc, stub, "merge", exported)
+
+	for _, conflict := range []string{"marble m1 already exists", "balance of alice already set"}{
+		found := false
+		for _, msg := range report.Conflicts{
+			found = found || strings.HasPrefix(msg, conflict)
+		}
+		if !found {
+			t.Errorf("no conflict %q in %v", conflict, report.Conflicts)
+		}
+	}
+	checkOwner(t, stub, "m1", "bob")
+	checkOwner(t, stub, "m2", "bob")
+	checkOwner(t, stub, "m3", "carol")
+	checkBalance(t, cc, stub, "alice", 101)
+	var snapshot marbles.Snapshot
+	json.Unmarshal([]byte(exportState(t, cc, stub)), &snapshot)
+	if snapshot.Config.FeeSchedule.Mode != "none" {
+		t.Fatalf("merge took the fee schedule %+v", snapshot.Config.FeeSchedule)
+	}
+}
+
+func TestImportReplace(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	source := snapshotLedger(t, cc)
+	mustInvoke(t, cc, source, "alice", "init_marble", "gone", "red", "5", "alice")
+	withGone := exportState(t, cc, source)
+	mustInvoke(t, cc, source, "alice", "burn_marble", "gone", "alice")
+	exported := exportState(t, cc, source)
+
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "admin", "set_approval_rules", "admin", `{"rules": [{"min_size": 35, "approvers": ["dave"], "required": 1}]}`)
+	mustInvoke(t, cc, stub, "dave", "init_marble", "old", "red", "35", "dave")
+	pending := string(mustInvoke(t, cc, stub, "dave", "set_user", "old", "alice"))
+	mustInvoke(t, cc, stub, "alice", "init_marble", "gone", "red", "5", "alice")
+	mustInvoke(t, cc, stub, "alice", "burn_marble", "gone", "alice")
+
+	mustFail(t, cc, stub, "admin", "import_state", "admin", "replace", withGone)		//gone was burned here, its name stays taken
+	report := importState(t, cc, stub, "replace", exported)
+	if report.DroppedPending != 1 {
+		t.Fatalf("replace reported %+v", report)
+	}
+	if marble, _ := stub.GetState("old"); marble != nil {
+		t.Fatalf("replace left old behind: %s", marble)
+	}
+	mustFail(t, cc, stub, "dave", "approve_transfer", pending, "dave")
+	if res, _, _ := apply(cc, stub, "dave", "query", "pending_transfers", nil); string(res) != `{"transfers":null}` {
+		t.Fatalf("replace kept pending transfers %s", res)
+	}
+	var snapshot marbles.Snapshot
+	json.Unmarshal([]byte(exportState(t, cc, stub)), &snapshot)
+	if snapshot.Config.FeeSchedule.Mode != "flat" || !reflect.DeepEqual(snapshot.Config.ApprovalRules.Rules[0].Approvers, []string{"dave"}) || snapshot.Config.ApprovalRules.Rules[0].MinSize != 100 {
+		t.Fatalf("replace did not take the config: %+v", snapshot.Config)
+	}
+}
@@ -0,0 +1,399 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+var statsStr = "_stats"							//name for the key/value that will store the running counters
+var maxStatsDays = 366							//longest window stats will count completed trades over, it walks it a day at a time
+var sizeBucketWidth = 10						//marble sizes are counted in buckets this wide
+
+type Stats struct{
+	Total int `json:"total"`					//live marbles
+	Owners map[string]int `json:"owners"`		//user -> live marbles held
+	Colors map[string]int `json:"colors"`		//color -> live marbles
+	Sizes map[string]int `json:"sizes"`			//size bucket "10-19" -> live marbles
+	OpenTrades map[string]int `json:"open_trades"`	//user -> open trades
+	Wanted map[string]int `json:"wanted"`		//"color size" -> open trades wanting it
+	Completed map[string]int `json:"completed"`	//utc day (2006-01-02) -> trades completed that day
+}
+
+type WantedCount struct{
+	Color string `json:"color"`
+	Size int `json:"size"`
+	Count int `json:"count"`
+}
+
+type CompletedCount struct{
+	Days int `json:"days"`						//window ending today
+	Total int `json:"total"`
+	PerDay map[string]int `json:"per_day"`
+}
+
+type StatsReport struct{
+	Total int `json:"total"`
+	Owners map[string]int `json:"owners"`
+	Colors map[string]int `json:"colors"`
+	Sizes map[string]int `json:"sizes"`
+	OpenTrades map[string]int `json:"open_trades"`
+	MostWanted []WantedCount `json:"most_wanted"`
+	Completed CompletedCount `json:"completed"`
+}
+
+type byCount []WantedCount
+
+func (s byCount) Len() int { return len(s) }
+func (s byCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	if s[i].Color != s[j].Color {
+		return s[i].Color < s[j].Color
+	}
+	return s[i].Size < s[j].Size
+}
+
+// statsStub keeps the running counters in memory for the length of one invoke, every handler bumps them as it writes
+// and they reach the ledger in a single write once it is done
+type statsStub struct{
+	Stub
+	stats []byte
+	loaded bool
+	dirty bool
+}
+
+func newStatsStub(stub Stub) *statsStub {
+	return &statsStub{Stub: stub}
+}
+
+func (c *statsStub) GetState(key string) ([]byte, error) {
+	if key != statsStr {
+		return c.Stub.GetState(key)
+	}
+	if !c.loaded {
+		statsAsBytes, err := c.Stub.GetState(statsStr)
+		if err != nil {
+			return nil, err
+		}
+		c.stats = statsAsBytes
+		c.loaded = true
+	}
+	return c.stats, nil
+}
+
+func (c *statsStub) PutState(key string, value []byte) error {
+	if key != statsStr {
+		return c.Stub.PutState(key, value)
+	}
+	c.stats = append([]byte{}, value...)
+	c.loaded = true
+	c.dirty = true
+	return nil
+}
+
+func (c *statsStub) DelState(key string) error {
+	if key == statsStr {
+		c.loaded = false
+		c.dirty = false
+	}
+	return c.Stub.DelState(key)
+}
+
+func (c *statsStub) SetEvent(name string, payload []byte) error {
+	if eventStub, ok := c.Stub.(EventStub); ok {
+		return eventStub.SetEvent(name, payload)
+	}
+	return nil
+}
+
+func (c *statsStub) TxTimestamp() (int64, error) {
+	if timestampStub, ok := c.Stub.(TimestampStub); ok {
+		return timestampStub.TxTimestamp()
+	}
+	return 0, errors.New("Stub has no transaction timestamp")
+}
+
+// ============================================================================================================================
+// Flush - write the counters to the ledger if the invoke changed them
+// ============================================================================================================================
+func (c *statsStub) flush() error {
+	if !c.dirty {
+		return nil
+	}
+	c.dirty = false
+	return c.Stub.PutState(statsStr, c.stats)
+}
+
+// ============================================================================================================================
+// Get Stats - read the running counters
+// ============================================================================================================================
+func getStats(stub Stub) (Stats, error) {
+	var stats Stats
+	statsAsBytes, err := stub.GetState(statsStr)
+	if err != nil {
+		return stats, errors.New("Failed to get stats")
+	}
+	json.Unmarshal(statsAsBytes, &stats)										//un stringify it aka JSON.parse()
+	if stats.Owners == nil {
+		stats.Owners = make(map[string]int)
+	}
+	if stats.Colors == nil {
+		stats.Colors = make(map[string]int)
+	}
+	if stats.Sizes == nil {
+		stats.Sizes = make(map[string]int)
+	}
+	if stats.OpenTrades == nil {
+		stats.OpenTrades = make(map[string]int)
+	}
+	if stats.Wanted == nil {
+		stats.Wanted = make(map[string]int)
+	}
+	if stats.Completed == nil {
+		stats.Completed = make(map[string]int)
+	}
+	return stats, nil
+}
+
+// ============================================================================================================================
+// Put Stats - rewrite the running counters
+// ============================================================================================================================
+func putStats(stub Stub, stats Stats) error {
+	jsonAsBytes, _ := json.Marshal(stats)
+	return stub.PutState(statsStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Size Bucket - the bucket a marble size is counted in
+// ============================================================================================================================
+func sizeBucket(size int) string {
+	low := (size / sizeBucketWidth) * sizeBucketWidth
+	return strconv.Itoa(low) + "-" + strconv.Itoa(low + sizeBucketWidth - 1)
+}
+
+// ============================================================================================================================
+// Bump - add delta to a counter, dropping it once it reaches zero
+// ============================================================================================================================
+func bump(counts map[string]int, key string, delta int) {
+	counts[key] += delta
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
+
+// ============================================================================================================================
+// Count Marble - add (1) or take away (-1) a marble from the counters, only live marbles count
+// ============================================================================================================================
+func countMarble(stats *Stats, marble Marble, delta int) {
+	if marble.Name == "" || marble.Status != "" {
+		return
+	}
+	stats.Total += delta
+	bump(stats.Owners, normalizeUser(marble.User), delta)
+	bump(stats.Colors, strings.ToLower(marble.Color), delta)
+	bump(stats.Sizes, sizeBucket(marble.Size), delta)
+}
+
+// ============================================================================================================================
+// Count Trade - add (1) or take away (-1) an open trade from the counters
+// ============================================================================================================================
+func countTrade(stats *Stats, trade AnOpenTrade, delta int) {
+	bump(stats.OpenTrades, normalizeUser(trade.User), delta)
+	bump(stats.Wanted, strings.ToLower(trade.Want.Color) + " " + strconv.Itoa(trade.Want.Size), delta)
+}
+
+// ============================================================================================================================
+// Update Marble Stats - a marble changed from old to new, either may be empty for a create or delete
+// ============================================================================================================================
+func updateMarbleStats(stub Stub, old Marble, marble Marble) error {
+	stats, err := getStats(stub)
+	if err != nil {
+		return err
+	}
+	countMarble(&stats, old, -1)
+	countMarble(&stats, marble, 1)
+	return putStats(stub, stats)
+}
+
+// ============================================================================================================================
+// Update Trade Stats - the open trades changed from old to new, count the ones that came and went
+// ============================================================================================================================
+func updateTradeStats(stub Stub, old AllTrades, trades AllTrades) error {
+	stats, err := getStats(stub)
+	if err != nil {
+		return err
+	}
+	before := make(map[int64]bool)
+	for _, trade := range old.OpenTrades{
+		before[trade.Timestamp] = true
+	}
+	after := make(map[int64]bool)
+	for _, trade := range trades.OpenTrades{
+		after[trade.Timestamp] = true
+		if !before[trade.Timestamp] {
+			countTrade(&stats, trade, 1)
+		}
+	}
+	for _, trade := range old.OpenTrades{
+		if !after[trade.Timestamp] {
+			countTrade(&stats, trade, -1)
+		}
+	}
+	return putStats(stub, stats)
+}
+
+// ============================================================================================================================
+// Count Completed Trade - a trade settled, count it against today
+// ============================================================================================================================
+func countCompletedTrade(stub Stub) error {
+	stats, err := getStats(stub)
+	if err != nil {
+		return err
+	}
+	day := time.Unix(0, makeTimestamp(stub) * int64(time.Millisecond)).UTC().Format("2006-01-02")
+	stats.Completed[day]++
+	return putStats(stub, stats)
+}
+
+// ============================================================================================================================
+// rebuildStats - recount marbles and open trades from scratch, completed trade counts are kept
+// ============================================================================================================================
+func rebuildStats(stub Stub) error {
+	stats, err := recountStats(stub)
+	if err != nil {
+		return err
+	}
+	return putStats(stub, stats)
+}
+
+// ============================================================================================================================
+// recountStats - count marbles and open trades from scratch without storing them, completed trade counts are kept
+// ============================================================================================================================
+func recountStats(stub Stub) (Stats, error) {
+	old, err := getStats(stub)
+	if err != nil {
+		return old, err
+	}
+	stats := Stats{Owners: make(map[string]int), Colors: make(map[string]int), Sizes: make(map[string]int), OpenTrades: make(map[string]int), Wanted: make(map[string]int), Completed: old.Completed}
+
+	marbleIndex, err := getMarbleIndex(stub)
+	if err != nil {
+		return stats, err
+	}
+	for i := range marbleIndex{
+		marble, err := getMarble(stub, marbleIndex[i])
+		if err != nil {
+			continue															//index points at a deleted key, nothing to count
+		}
+		countMarble(&stats, marble, 1)
+	}
+	trades, err := getTrades(stub)
+	if err != nil {
+		return stats, err
+	}
+	for _, trade := range trades.OpenTrades{
+		countTrade(&stats, trade, 1)
+	}
+	return stats, nil
+}
+
+// ============================================================================================================================
+// Rebuild Stats - admin recounts marbles and open trades from scratch, completed trade counts are kept
+// ============================================================================================================================
+func (t *Chaincode) rebuild_stats(stub Stub, args []string) ([]byte, error) {
+
+	//   0
+	// "admin"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start rebuild stats")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	err := rebuildStats(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end rebuild stats")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Stats - read the marble and trade counters, completed trades over the last few days and the most wanted marbles
+// ============================================================================================================================
+func (t *Chaincode) stats(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//    0        1
+	// *"7"*, *"10"*
+	days := 7
+	top := 10
+	if len(args) > 0 {
+		days, err = strconv.Atoi(args[0])
+		if err != nil || days <= 0 || days > maxStatsDays {
+			return nil, errors.New("1st argument must be a number of days between 1 and " + strconv.Itoa(maxStatsDays))
+		}
+	}
+	if len(args) > 1 {
+		top, err = strconv.Atoi(args[1])
+		if err != nil || top <= 0 {
+			return nil, errors.New("2nd argument must be a positive number of descriptions")
+		}
+	}
+
+	stats, err := getStats(stub)
+	if err != nil {
+		return nil, err
+	}
+	report := StatsReport{Total: stats.Total, Owners: stats.Owners, Colors: stats.Colors, Sizes: stats.Sizes, OpenTrades: stats.OpenTrades, MostWanted: []WantedCount{}}
+	for key, count := range stats.Wanted{
+		pos := strings.LastIndex(key, " ")
+		size, _ := strconv.Atoi(key[pos+1:])
+		report.MostWanted = append(report.MostWanted, WantedCount{Color: key[:pos], Size: size, Count: count})
+	}
+	sort.Sort(byCount(report.MostWanted))
+	if len(report.MostWanted) > top {
+		report.MostWanted = report.MostWanted[:top]
+	}
+
+	report.Completed = CompletedCount{Days: days, PerDay: make(map[string]int)}
+	now := time.Unix(0, makeTimestamp(stub) * int64(time.Millisecond)).UTC()
+	for i := 0; i < days; i++ {
+		day := now.AddDate(0, 0, -i).Format("2006-01-02")
+		if stats.Completed[day] > 0 {
+			report.Completed.PerDay[day] = stats.Completed[day]
+			report.Completed.Total += stats.Completed[day]
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
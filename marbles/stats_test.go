@@ -0,0 +1,180 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+var day = int64(24 * 60 * 60 * 1000)
+
+func TestStatsSizeBuckets(t *testing.T) {
+	for _, test := range []struct{
+		sizes []string
+		buckets map[string]int
+	}{
+		{[]string{"5"}, map[string]int{"0-9": 1}},
+		{[]string{"9", "10"}, map[string]int{"0-9": 1, "10-19": 1}},
+		{[]string{"10", "16", "19"}, map[string]int{"10-19": 3}},
+		{[]string{"35", "100"}, map[string]int{"30-39": 1, "100-109": 1}},
+	}{
+		cc := marbles.NewChaincode(marbles.Part2)
+		stub := setup(t, cc)
+		for i, size := range test.sizes{
+			mustInvoke(t, cc, stub, "alice", "init_marble", "m" + strconv.Itoa(i), "red", size, "alice")
+		}
+		report := readStats(t, cc, stub)
+		if !reflect.DeepEqual(report.Sizes, test.buckets) || report.Total != len(test.sizes) {
+			t.Errorf("sizes %v: %d marbles in %v, want %v", test.sizes, report.Total, report.Sizes, test.buckets)
+		}
+	}
+}
+
+func TestStatsMostWanted(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	for _, want := range [][]string{
+		{"alice", "blue", "16"}, {"bob", "blue", "16"}, {"carol", "red", "5"}, {"dave", "red", "5"},
+		{"alice", "green", "16"}, {"bob", "green", "5"}, {"carol", "amber", "35"},
+	}{
+		mustInvoke(t, cc, stub, want[0], "open_trade", want[0], want[1], want[2], "1")
+	}
+	all := []marbles.WantedCount{
+		{Color: "blue", Size: 16, Count: 2}, {Color: "red", Size: 5, Count: 2},	//most wanted first, ties by color then size
+		{Color: "amber", Size: 35, Count: 1}, {Color: "green", Size: 5, Count: 1}, {Color: "green", Size: 16, Count: 1},
+	}
+	for _, test := range []struct{
+		args []string
+		want []marbles.WantedCount
+	}{
+		{nil, all},
+		{[]string{"7", "3"}, all[:3]},
+		{[]string{"7", "1"}, all[:1]},
+		{[]string{"7", "50"}, all},
+	}{
+		if report := readStats(t, cc, stub, test.args...); !reflect.DeepEqual(report.MostWanted, test.want) {
+			t.Errorf("stats %v: most wanted %+v, want %+v", test.args, report.MostWanted, test.want)
+		}
+	}
+}
+
+func TestStatsCompletedWindow(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	start := stub.Timestamp
+	for i, offset := range []int64{0, 6, 7, 7}{									//days after the start a trade completes, the last is today
+		stub.Timestamp = start + offset * day
+		name := "m" + strconv.Itoa(i)
+		mustInvoke(t, cc, stub, "bob", "init_marble", name, "blue", "16", "bob")
+		mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "1")
+		id := strconv.FormatInt(openTrades(t, cc, stub)[0].Timestamp, 10)
+		mustInvoke(t, cc, stub, "bob", "perform_trade", id, name, "-1")
+	}
+
+	for _, test := range []struct{
+		days string
+		total int
+		ok bool
+	}{
+		{"0", 0, false},
+		{"1", 2, true},															//today only
+		{"2", 3, true},
+		{"7", 3, true},															//the first trade is 7 days back, one outside the window
+		{"8", 4, true},
+		{"366", 4, true},
+		{"367", 0, false},
+	}{
+		_, err, _ := apply(cc, stub, "alice", "query", "stats", []string{test.days})
+		if (err == nil) != test.ok {
+			t.Errorf("stats over %s days: %v", test.days, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		report := readStats(t, cc, stub, test.days)
+		days, _ := strconv.Atoi(test.days)
+		if report.Completed.Total != test.total || report.Completed.Days != days {
+			t.Errorf("stats over %s days: %+v, want %d completed", test.days, report.Completed, test.total)
+		}
+	}
+}
+
+// writeCounter counts the ledger writes of the stats key
+type writeCounter struct{
+	*memstub.Stub
+	writes int
+}
+
+func (w *writeCounter) PutState(key string, value []byte) error {
+	if key == "_stats" {
+		w.writes++
+	}
+	return w.Stub.PutState(key, value)
+}
+
+func TestStatsWrittenOncePerInvoke(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	stub := setup(t, cc)
+	mustInvoke(t, cc, stub, "alice", "init_marble", "m0", "red", "16", "alice")
+	mustInvoke(t, cc, stub, "bob", "init_marble", "m1", "blue", "16", "bob")
+	mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16")
+	id := strconv.FormatInt(openTrades(t, cc, stub)[0].Timestamp, 10)
+
+	counter := &writeCounter{Stub: stub}
+	for _, call := range [][]string{
+		{"alice", "init_marbles", `[{"name": "a", "color": "red", "size": 5, "user": "alice"}, {"name": "b", "color": "red", "size": 5, "user": "alice"}, {"name": "c", "color": "red", "size": 5, "user": "alice"}]`},
+		{"alice", "transfer_marbles", `[{"name": "a", "user": "bob"}, {"name": "b", "user": "bob"}, {"name": "c", "user": "bob"}]`},
+		{"bob", "perform_trade", id, "m1", "0"},
+	}{
+		counter.writes = 0
+		stub.Caller = call[0]
+		if _, err := cc.Invoke(counter, call[1], call[2:]); err != nil {
+			t.Fatal(err)
+		}
+		if counter.writes != 1 {
+			t.Errorf("%s wrote the stats %d times", call[1], counter.writes)
+		}
+	}
+	broken, err := marbles.CheckInvariants(stub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range broken{
+		t.Error(msg)
+	}
+}
+
+func readStats(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, args ...string) marbles.StatsReport {
+	t.Helper()
+	res, err, _ := apply(cc, stub, "alice", "query", "stats", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report marbles.StatsReport
+	json.Unmarshal(res, &report)
+	return report
+}
@@ -0,0 +1,184 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"encoding/json"
+)
+
+var balancesStr = "_balances"					//name for the key/value that will store the token balance of every user
+
+type Balance struct{
+	User string `json:"user"`
+	Balance int `json:"balance"`
+}
+
+// ============================================================================================================================
+// Get Balances - read the token ledger, user -> balance
+// ============================================================================================================================
+func getBalances(stub Stub) (map[string]int, error) {
+	balancesAsBytes, err := stub.GetState(balancesStr)
+	if err != nil {
+		return nil, errors.New("Failed to get balances")
+	}
+	balances := make(map[string]int)
+	json.Unmarshal(balancesAsBytes, &balances)									//un stringify it aka JSON.parse()
+	return balances, nil
+}
+
+// ============================================================================================================================
+// Put Balances - rewrite the token ledger
+// ============================================================================================================================
+func putBalances(stub Stub, balances map[string]int) error {
+	jsonAsBytes, _ := json.Marshal(balances)
+	return stub.PutState(balancesStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Move Tokens - debit one user and credit another, errors if the sender cannot afford it
+// ============================================================================================================================
+func moveTokens(balances map[string]int, from string, to string, amount int) error {
+	err := debitTokens(balances, from, amount)
+	if err != nil {
+		return err
+	}
+	balances[normalizeUser(to)] += amount
+	return nil
+}
+
+// ============================================================================================================================
+// Debit Tokens - take tokens out of a user's balance, errors if the user cannot afford it
+// ============================================================================================================================
+func debitTokens(balances map[string]int, user string, amount int) error {
+	user = normalizeUser(user)
+	if amount < 0 {
+		return errors.New("Token amount must not be negative")
+	}
+	if balances[user] < amount {
+		return errors.New(user + " has insufficient tokens, has " + strconv.Itoa(balances[user]) + " needs " + strconv.Itoa(amount))
+	}
+	balances[user] -= amount
+	return nil
+}
+
+// ============================================================================================================================
+// Mint Tokens - admin creates new tokens in a user's balance
+// ============================================================================================================================
+func (t *Chaincode) mint_tokens(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0        1      2
+	// "admin", "bob", "100"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start mint tokens")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	user, err := checkActiveUser(stub, args[1])									//tokens only go to users who can spend them
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount <= 0 {
+		return nil, errors.New("3rd argument must be a positive numeric string")
+	}
+
+	balances, err := getBalances(stub)
+	if err != nil {
+		return nil, err
+	}
+	balances[user] += amount
+	err = putBalances(stub, balances)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end mint tokens")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Transfer Tokens - move tokens from one user to another, signed by the user paying
+// ============================================================================================================================
+func (t *Chaincode) transfer_tokens(stub Stub, args []string) ([]byte, error) {
+	var err error
+
+	//   0       1        2
+	// "bob", "alice", "25"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start transfer tokens")
+	err = checkSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if normalizeUser(args[0]) == normalizeUser(args[1]) {
+		return nil, errors.New("Cannot transfer tokens to yourself")
+	}
+	_, err = checkActiveUser(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount <= 0 {
+		return nil, errors.New("3rd argument must be a positive numeric string")
+	}
+
+	balances, err := getBalances(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = moveTokens(balances, args[0], args[1], amount)
+	if err != nil {
+		return nil, err
+	}
+	err = putBalances(stub, balances)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end transfer tokens")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Balance Of - read the token balance of a user
+// ============================================================================================================================
+func (t *Chaincode) balance_of(stub Stub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the user to query")
+	}
+
+	balances, err := getBalances(stub)
+	if err != nil {
+		return nil, err
+	}
+	user := normalizeUser(args[0])
+	jsonAsBytes, _ := json.Marshal(Balance{User: user, Balance: balances[user]})
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,91 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+)
+
+var tradePolicyStr = "_tradepolicy"				//name for the key/value that will store how perform_trade checks a trade
+
+type TradePolicy struct{
+	StrictWant bool `json:"strict_want"`				//the closer's marble must match what the opener wants
+	AllowPartial bool `json:"allow_partial"`			//a trade stays open after a fill, only the used willing option goes
+}
+
+// ============================================================================================================================
+// Get Trade Policy - read the trade policy, the preset's policy until an admin sets one
+// ============================================================================================================================
+func (t *Chaincode) getTradePolicy(stub Stub) (TradePolicy, error) {
+	policy := t.preset.TradePolicy
+	policyAsBytes, err := stub.GetState(tradePolicyStr)
+	if err != nil {
+		return policy, errors.New("Failed to get trade policy")
+	}
+	if policyAsBytes != nil {
+		json.Unmarshal(policyAsBytes, &policy)									//un stringify it aka JSON.parse()
+	}
+	return policy, nil
+}
+
+// ============================================================================================================================
+// Set Trade Policy - admin replaces the trade policy with a JSON document
+// ============================================================================================================================
+func (t *Chaincode) set_trade_policy(stub Stub, args []string) ([]byte, error) {
+
+	//   0         1
+	// "admin", "{"strict_want": true, "allow_partial": false}"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start set trade policy")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	var policy TradePolicy
+	err := json.Unmarshal([]byte(args[1]), &policy)
+	if err != nil {
+		return nil, errors.New("2nd argument must be a JSON trade policy")
+	}
+
+	jsonAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(tradePolicyStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set trade policy")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Trade Policy - read the trade policy perform_trade is applying
+// ============================================================================================================================
+func (t *Chaincode) get_trade_policy(stub Stub, args []string) ([]byte, error) {
+	policy, err := t.getTradePolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(policy)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+// alice wants a blue 16 and is willing to give a red 16 or a green 16, plus 10 tokens each time
+func TestTradePolicy(t *testing.T) {
+	for _, test := range []struct{
+		strict bool
+		partial bool
+		closer string								//bob's m3 is a blue 16, m4 a yellow 5
+		ok bool
+		options int									//willing options left open after the fill, -1 for the trade closed
+	}{
+		{strict: false, partial: false, closer: "m3", ok: true, options: -1},
+		{strict: false, partial: false, closer: "m4", ok: true, options: -1},
+		{strict: true, partial: false, closer: "m3", ok: true, options: -1},
+		{strict: true, partial: false, closer: "m4", ok: false},
+		{strict: false, partial: true, closer: "m3", ok: true, options: 1},
+		{strict: false, partial: true, closer: "m4", ok: true, options: 1},
+		{strict: true, partial: true, closer: "m3", ok: true, options: 1},
+		{strict: true, partial: true, closer: "m4", ok: false},
+	}{
+		name := "strict_want=" + strconv.FormatBool(test.strict) + " allow_partial=" + strconv.FormatBool(test.partial) + " closer=" + test.closer
+		t.Run(name, func(t *testing.T) {
+			cc := marbles.NewChaincode(marbles.Part2)
+			stub := setup(t, cc)
+			policyAsBytes, _ := json.Marshal(marbles.TradePolicy{StrictWant: test.strict, AllowPartial: test.partial})
+			mustInvoke(t, cc, stub, "admin", "set_trade_policy", "admin", string(policyAsBytes))
+			for _, call := range [][]string{
+				{"alice", "m1", "red", "16"}, {"alice", "m2", "green", "16"},
+				{"bob", "m3", "blue", "16"}, {"bob", "m4", "yellow", "5"}, {"bob", "m5", "blue", "16"},
+			}{
+				mustInvoke(t, cc, stub, call[0], "init_marble", call[1], call[2], call[3], call[0])
+			}
+			mustInvoke(t, cc, stub, "alice", "open_trade", "alice", "blue", "16", "red", "16", "green", "16", "10")
+			id := strconv.FormatInt(openTrades(t, cc, stub)[0].Timestamp, 10)
+
+			if !test.ok {
+				mustFail(t, cc, stub, "bob", "perform_trade", id, test.closer, "0")
+				return
+			}
+			mustInvoke(t, cc, stub, "bob", "perform_trade", id, test.closer, "0")
+			checkOwner(t, stub, "m1", "bob")
+			checkOwner(t, stub, test.closer, "alice")
+			checkBalance(t, cc, stub, "alice", 90)
+			checkBalance(t, cc, stub, "bob", 110)
+			trades := openTrades(t, cc, stub)
+			if test.options < 0 {
+				if len(trades) != 0 {
+					t.Fatalf("trade still open with %d options", len(trades[0].Willing))
+				}
+				return
+			}
+			if len(trades) != 1 || len(trades[0].Willing) != test.options {
+				t.Fatalf("open trades after a partial fill: %+v", trades)
+			}
+
+			mustInvoke(t, cc, stub, "bob", "perform_trade", id, "m5", "0")			//the last option closes it, the price is paid again
+			checkOwner(t, stub, "m2", "bob")
+			checkBalance(t, cc, stub, "alice", 80)
+			checkBalance(t, cc, stub, "bob", 120)
+			if trades := openTrades(t, cc, stub); len(trades) != 0 {
+				t.Fatalf("trade still open after its last option: %+v", trades)
+			}
+		})
+	}
+}
+
+func checkOwner(t *testing.T, stub *memstub.Stub, name string, user string) {
+	t.Helper()
+	var marble marbles.Marble
+	marbleAsBytes, _ := stub.GetState(name)
+	json.Unmarshal(marbleAsBytes, &marble)
+	if marble.User != user {
+		t.Fatalf("%s is %s's, want %s's", name, marble.User, user)
+	}
+}
+
+func checkBalance(t *testing.T, cc *marbles.Chaincode, stub *memstub.Stub, user string, balance int) {
+	t.Helper()
+	res, err, _ := apply(cc, stub, user, "query", "balance_of", []string{user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got marbles.Balance
+	json.Unmarshal(res, &got)
+	if got.Balance != balance {
+		t.Fatalf("%s has %d tokens, want %d", user, got.Balance, balance)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package marbles
+
+import (
+	"errors"
+	"fmt"
+	"encoding/json"
+	"strings"
+)
+
+var usersStr = "_users"							//name for the key/value that will store every registered user
+
+type User struct{
+	ID string `json:"id"`						//normalized user id, what marbles store as their user
+	DisplayName string `json:"display_name"`
+	Company string `json:"company"`
+	Status string `json:"status"`				//"active" or "suspended"
+	Created int64 `json:"created"`				//utc timestamp of registration
+}
+
+// ============================================================================================================================
+// Normalize User - the one canonical form of a user id, every user id is run through this before use
+// ============================================================================================================================
+func normalizeUser(user string) string {
+	return strings.ToLower(strings.TrimSpace(user))
+}
+
+// ============================================================================================================================
+// Get Users - read the user registry, id -> user
+// ============================================================================================================================
+func getUsers(stub Stub) (map[string]User, error) {
+	usersAsBytes, err := stub.GetState(usersStr)
+	if err != nil {
+		return nil, errors.New("Failed to get users")
+	}
+	users := make(map[string]User)
+	json.Unmarshal(usersAsBytes, &users)										//un stringify it aka JSON.parse()
+	return users, nil
+}
+
+// ============================================================================================================================
+// Put Users - rewrite the user registry
+// ============================================================================================================================
+func putUsers(stub Stub, users map[string]User) error {
+	jsonAsBytes, _ := json.Marshal(users)
+	return stub.PutState(usersStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Check Active User - errors if the user is not registered or is suspended, returns the normalized id
+// ============================================================================================================================
+func checkActiveUser(stub Stub, user string) (string, error) {
+	id := normalizeUser(user)
+	users, err := getUsers(stub)
+	if err != nil {
+		return id, err
+	}
+	profile, ok := users[id]
+	if !ok {
+		return id, errors.New("User '" + user + "' is not registered")
+	}
+	if profile.Status != "active" {
+		return id, errors.New("User '" + user + "' is " + profile.Status)
+	}
+	return id, nil
+}
+
+// ============================================================================================================================
+// Register User - the signer creates their own user record
+// ============================================================================================================================
+func (t *Chaincode) register_user(stub Stub, args []string) ([]byte, error) {
+
+	//   0          1            2
+	// "bob", "Bob Smith", "United Marbles"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start register user")
+	id := normalizeUser(args[0])
+	if len(id) <= 0 || strings.HasPrefix(id, "_") {
+		return nil, errors.New("1st argument must be a non-empty string not starting with _")
+	}
+	err := checkSigner(stub, id)												//users register themselves
+	if err != nil {
+		return nil, err
+	}
+	users, err := getUsers(stub)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := users[id]; ok {
+		return nil, errors.New("User '" + id + "' is already registered")
+	}
+
+	users[id] = User{ID: id, DisplayName: args[1], Company: args[2], Status: "active", Created: makeTimestamp(stub)}
+	err = putUsers(stub, users)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end register user")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Set User Status - admin suspends or reactivates a user
+// ============================================================================================================================
+func (t *Chaincode) set_user_status(stub Stub, args []string) ([]byte, error) {
+
+	//   0        1         2
+	// "admin", "bob", "suspended"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start set user status")
+	if !isAdmin(stub, args[0]) {
+		return nil, errors.New(args[0] + " is not an admin")
+	}
+	status := strings.ToLower(args[2])
+	if status != "active" && status != "suspended" {
+		return nil, errors.New("3rd argument must be active or suspended")
+	}
+	users, err := getUsers(stub)
+	if err != nil {
+		return nil, err
+	}
+	id := normalizeUser(args[1])
+	profile, ok := users[id]
+	if !ok {
+		return nil, errors.New("User '" + args[1] + "' is not registered")
+	}
+
+	profile.Status = status
+	users[id] = profile
+	err = putUsers(stub, users)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set user status")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get User - read a user record
+// ============================================================================================================================
+func (t *Chaincode) get_user(stub Stub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the user to query")
+	}
+
+	users, err := getUsers(stub)
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := users[normalizeUser(args[0])]
+	if !ok {
+		return nil, errors.New("User '" + args[0] + "' is not registered")
+	}
+	jsonAsBytes, _ := json.Marshal(profile)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,189 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package memstub is a stub for the marbles chaincode that keeps state in memory and can save it to a JSON file
+package memstub
+
+import (
+	"errors"
+	"os"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+)
+
+// Stub holds the key/value state of one ledger
+type Stub struct {
+	path string
+	state map[string][]byte
+	Events []marbles.Event								//events set since the last ClearEvents
+	Timestamp int64										//transaction timestamp in ms to hand the chaincode, 0 uses the clock
+	Caller string										//user signing the transactions, empty fails anything that checks who
+}
+
+// ============================================================================================================================
+// New - an empty stub that lives only in memory
+// ============================================================================================================================
+func New() *Stub {
+	return &Stub{state: make(map[string][]byte)}
+}
+
+// ============================================================================================================================
+// Open - load a stub from a JSON state file, a missing file is an empty ledger
+// ============================================================================================================================
+func Open(path string) (*Stub, error) {
+	s := New()
+	s.path = path
+	fileAsBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state map[string]string
+	err = json.Unmarshal(fileAsBytes, &state)
+	if err != nil {
+		return nil, errors.New(path + " is not a JSON state file")
+	}
+	for key, value := range state{
+		s.state[key] = []byte(value)
+	}
+	return s, nil
+}
+
+// ============================================================================================================================
+// Save - write the state back to the file it was opened from
+// ============================================================================================================================
+func (s *Stub) Save() error {
+	if s.path == "" {
+		return errors.New("Stub was not opened from a file")
+	}
+	jsonAsBytes, _ := json.MarshalIndent(s.Snapshot(), "", "  ")
+	tmp := s.path + ".tmp"
+	err := ioutil.WriteFile(tmp, jsonAsBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)										//a crash leaves the old file whole
+}
+
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	s.state[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	delete(s.state, key)
+	return nil
+}
+
+func (s *Stub) GetCaller() (string, error) {
+	if s.Caller == "" {
+		return "", errors.New("No caller set")
+	}
+	return s.Caller, nil
+}
+
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	s.Events = append(s.Events, marbles.Event{Name: name, Payload: append([]byte{}, payload...)})
+	return nil
+}
+
+func (s *Stub) TxTimestamp() (int64, error) {
+	if s.Timestamp == 0 {
+		return 0, errors.New("No transaction timestamp set")
+	}
+	return s.Timestamp, nil
+}
+
+// ============================================================================================================================
+// Clear Events - forget the events set so far
+// ============================================================================================================================
+func (s *Stub) ClearEvents() {
+	s.Events = nil
+}
+
+// ============================================================================================================================
+// Keys - every key in the state, sorted
+// ============================================================================================================================
+func (s *Stub) Keys() []string {
+	var keys []string
+	for key := range s.state{
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ============================================================================================================================
+// Snapshot - a copy of the state
+// ============================================================================================================================
+func (s *Stub) Snapshot() map[string]string {
+	snapshot := make(map[string]string)
+	for key, value := range s.state{
+		snapshot[key] = string(value)
+	}
+	return snapshot
+}
+
+// ============================================================================================================================
+// Restore - put the state back to a snapshot
+// ============================================================================================================================
+func (s *Stub) Restore(snapshot map[string]string) {
+	s.state = make(map[string][]byte)
+	for key, value := range snapshot{
+		s.state[key] = []byte(value)
+	}
+}
+
+// ============================================================================================================================
+// Diff - the keys that differ between two snapshots, sorted
+// ============================================================================================================================
+func Diff(before map[string]string, after map[string]string) []marbles.StateWrite {
+	var keys []string
+	for key := range before{
+		keys = append(keys, key)
+	}
+	for key := range after{
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	writes := []marbles.StateWrite{}
+	for _, key := range keys{
+		old, had := before[key]
+		value, has := after[key]
+		if !has {
+			writes = append(writes, marbles.StateWrite{Key: key, Before: old, Deleted: true})
+		} else if !had || old != value {
+			writes = append(writes, marbles.StateWrite{Key: key, Before: old, After: value})
+		}
+	}
+	return writes
+}
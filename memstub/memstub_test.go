@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package memstub_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+func TestSaveOpenRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memstub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	stub, err := memstub.Open(path)													//no file yet is an empty ledger
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.Keys()) != 0 {
+		t.Fatalf("new ledger has keys %v", stub.Keys())
+	}
+	stub.PutState("m1", []byte(`{"name":"m1","color":"red","size":16,"user":"alice"}`))
+	stub.PutState("_opentrades", []byte(`{"open_trades":[]}`))
+	stub.PutState("note", []byte("not JSON, \"quoted\"\n"))
+	stub.PutState("gone", []byte("x"))
+	stub.DelState("gone")
+	if err := stub.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("save left its temporary file behind: %v", err)
+	}
+
+	again, err := memstub.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Snapshot(), stub.Snapshot()) {
+		t.Fatalf("reopened %v, saved %v", again.Snapshot(), stub.Snapshot())
+	}
+	if keys := again.Keys(); !reflect.DeepEqual(keys, []string{"_opentrades", "m1", "note"}) {
+		t.Fatalf("keys %v", keys)
+	}
+}
+
+func TestOpenAndSaveErrors(t *testing.T) {
+	if err := memstub.New().Save(); err == nil {
+		t.Error("saved a stub that has no file")
+	}
+	file, err := ioutil.TempFile("", "memstub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("[1, 2, 3]")
+	file.Close()
+	if _, err := memstub.Open(file.Name()); err == nil {
+		t.Error("opened a file that is not a JSON state file")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	stub := memstub.New()
+	value := []byte("red")
+	stub.PutState("m1", value)
+	value[0] = 'b'																	//the stub keeps its own copy
+	before := stub.Snapshot()
+	stub.PutState("m1", []byte("green"))
+	stub.PutState("m2", []byte("blue"))
+	if before["m1"] != "red" || len(before) != 1 {
+		t.Fatalf("snapshot changed with the state: %v", before)
+	}
+	stub.Restore(before)
+	if !reflect.DeepEqual(stub.Snapshot(), before) {
+		t.Fatalf("restored %v, want %v", stub.Snapshot(), before)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]string{"changed": "1", "deleted": "2", "same": "3", "emptied": "4"}
+	after := map[string]string{"changed": "5", "same": "3", "emptied": "", "added": "6"}
+	want := []marbles.StateWrite{
+		{Key: "added", After: "6"},
+		{Key: "changed", Before: "1", After: "5"},
+		{Key: "deleted", Before: "2", Deleted: true},
+		{Key: "emptied", Before: "4"},												//still there, now empty
+	}
+	if writes := memstub.Diff(before, after); !reflect.DeepEqual(writes, want) {
+		t.Fatalf("diff %+v, want %+v", writes, want)
+	}
+	if writes := memstub.Diff(before, before); len(writes) != 0 {
+		t.Fatalf("diff of a snapshot with itself %+v", writes)
+	}
+}
+
+func TestStubIdentity(t *testing.T) {
+	stub := memstub.New()
+	if _, err := stub.GetCaller(); err == nil {
+		t.Error("read a caller when none is set")
+	}
+	if _, err := stub.TxTimestamp(); err == nil {
+		t.Error("read a transaction timestamp when none is set")
+	}
+	stub.Caller, stub.Timestamp = "alice", 1464000000000
+	caller, _ := stub.GetCaller()
+	timestamp, _ := stub.TxTimestamp()
+	if caller != "alice" || timestamp != 1464000000000 {
+		t.Errorf("caller %q at %d", caller, timestamp)
+	}
+	stub.SetEvent("transfer", []byte(`{"name":"m1"}`))
+	if len(stub.Events) != 1 || stub.Events[0].Name != "transfer" {
+		t.Fatalf("events %+v", stub.Events)
+	}
+	stub.ClearEvents()
+	if len(stub.Events) != 0 {
+		t.Fatalf("events left after clearing %+v", stub.Events)
+	}
+}
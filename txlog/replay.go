@@ -0,0 +1,155 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package txlog
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+type Divergence struct{
+	Index int `json:"index"`						//position in the log
+	Reason string `json:"reason"`
+	Recorded Entry `json:"recorded"`
+	Replayed Entry `json:"replayed"`
+}
+
+// ============================================================================================================================
+// Replay - run a log against a fresh ledger and stop at the first transaction that does something else this time
+// ============================================================================================================================
+func Replay(cc *marbles.Chaincode, entries []Entry) ([]Entry, *Divergence, error) {
+	err := checkSequence(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stub := memstub.New()
+	var replayed []Entry
+	prevHash := ""
+	for i := range entries{
+		entry := step(cc, stub, entries[i], prevHash)
+		replayed = append(replayed, entry)
+		prevHash = entry.Hash
+
+		reason := compare(entries[i], entry)
+		if reason != "" {
+			return replayed, &Divergence{Index: i, Reason: reason, Recorded: entries[i], Replayed: entry}, nil
+		}
+	}
+	return replayed, nil, nil
+}
+
+// ============================================================================================================================
+// Bisect - find the first transaction whose chained hash differs, -1 if they all match
+// each probe replays a prefix of the log from scratch, once the hash chain diverges it never comes back
+// ============================================================================================================================
+func Bisect(cc *marbles.Chaincode, entries []Entry) (int, error) {
+	err := checkSequence(entries)
+	if err != nil {
+		return -1, err
+	}
+
+	lo, hi := 0, len(entries)									//the first divergence is in [lo, hi), hi means none
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if hashAt(cc, entries, mid) == entries[mid].Hash {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == len(entries) {
+		return -1, nil
+	}
+	return lo, nil
+}
+
+// ============================================================================================================================
+// Hash At - replay the log up to and including index and give the hash it ends on
+// ============================================================================================================================
+func hashAt(cc *marbles.Chaincode, entries []Entry, index int) string {
+	stub := memstub.New()
+	prevHash := ""
+	for i := 0; i <= index; i++{
+		prevHash = step(cc, stub, entries[i], prevHash).Hash
+	}
+	return prevHash
+}
+
+// ============================================================================================================================
+// Step - replay one transaction as its recorded caller at its recorded timestamp, a failed invoke is rolled back like the peer would
+// ============================================================================================================================
+func step(cc *marbles.Chaincode, stub *memstub.Stub, recorded Entry, prevHash string) Entry {
+	entry := Entry{Seq: recorded.Seq, Kind: recorded.Kind, Function: recorded.Function, Args: recorded.Args, Caller: recorded.Caller, Timestamp: recorded.Timestamp}
+	before := stub.Snapshot()
+	_, err := execute(cc, stub, &entry, prevHash)
+	if err != nil && entry.Kind == "invoke" {
+		stub.Restore(before)
+	}
+	return entry
+}
+
+// ============================================================================================================================
+// Compare - why a replayed transaction differs from its record, empty if it does not
+// ============================================================================================================================
+func compare(recorded Entry, replayed Entry) string {
+	if recorded.Error != replayed.Error {
+		return "error was \"" + recorded.Error + "\", now \"" + replayed.Error + "\""
+	}
+	if recorded.Kind == "query" && recorded.Result != replayed.Result {
+		return "query result differs"
+	}
+
+	writes := make(map[string]Write)
+	for _, write := range recorded.Writes{
+		writes[write.Key] = write
+	}
+	for _, write := range replayed.Writes{
+		old, ok := writes[write.Key]
+		if !ok {
+			return "now writes " + write.Key
+		}
+		if old != write {
+			return "writes " + write.Key + " differently"
+		}
+		delete(writes, write.Key)
+	}
+	for _, write := range recorded.Writes{
+		if _, ok := writes[write.Key]; ok {
+			return "no longer writes " + write.Key
+		}
+	}
+	if recorded.Hash != replayed.Hash {
+		return "hash differs, an earlier entry is missing or edited"
+	}
+	return ""
+}
+
+func checkSequence(entries []Entry) error {
+	for i := range entries{
+		if entries[i].Seq != i + 1 {
+			return errors.New("Log does not start at the first transaction or has a gap at entry " + strconv.Itoa(i + 1))
+		}
+	}
+	return nil
+}
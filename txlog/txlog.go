@@ -0,0 +1,295 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package txlog records every invoke and query of the marbles chaincode and replays a record against a fresh ledger
+package txlog
+
+import (
+	"errors"
+	"strconv"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+)
+
+type Entry struct{
+	Seq int `json:"seq"`							//1 for the first transaction of the ledger
+	Kind string `json:"kind"`						//"invoke" or "query"
+	Function string `json:"function"`
+	Args []string `json:"args"`
+	Caller string `json:"caller"`					//user who signed it, empty if the stub knew no one
+	Timestamp int64 `json:"timestamp"`				//transaction timestamp in ms the chaincode was handed
+	Result string `json:"result"`
+	Error string `json:"error"`						//a failed invoke writes nothing
+	Writes []Write `json:"writes"`					//by key
+	Hash string `json:"hash"`						//chains the write sets of every transaction so far
+}
+
+type Write struct{
+	Key string `json:"key"`
+	Value string `json:"value"`
+	Deleted bool `json:"deleted"`
+}
+
+// recordingStub hands the chaincode the entry's caller and one timestamp per transaction, and keeps the last value it
+// wrote to each key
+type recordingStub struct{
+	base marbles.Stub
+	caller string
+	timestamp int64
+	before map[string][]byte							//ledger value from before the transaction touched the key
+	writes map[string][]byte
+	deleted map[string]bool
+}
+
+func newRecordingStub(base marbles.Stub, caller string, timestamp int64) *recordingStub {
+	return &recordingStub{base: base, caller: caller, timestamp: timestamp, before: make(map[string][]byte), writes: make(map[string][]byte), deleted: make(map[string]bool)}
+}
+
+func (s *recordingStub) GetState(key string) ([]byte, error) {
+	return s.base.GetState(key)
+}
+
+func (s *recordingStub) PutState(key string, value []byte) error {
+	err := s.touch(key)
+	if err != nil {
+		return err
+	}
+	s.writes[key] = append([]byte{}, value...)
+	delete(s.deleted, key)
+	return s.base.PutState(key, value)
+}
+
+func (s *recordingStub) DelState(key string) error {
+	err := s.touch(key)
+	if err != nil {
+		return err
+	}
+	delete(s.writes, key)
+	s.deleted[key] = true
+	return s.base.DelState(key)
+}
+
+func (s *recordingStub) SetEvent(name string, payload []byte) error {
+	if eventStub, ok := s.base.(marbles.EventStub); ok {
+		return eventStub.SetEvent(name, payload)
+	}
+	return nil
+}
+
+func (s *recordingStub) GetCaller() (string, error) {
+	if s.caller == "" {
+		return "", errors.New("No caller recorded")
+	}
+	return s.caller, nil
+}
+
+func (s *recordingStub) TxTimestamp() (int64, error) {
+	return s.timestamp, nil
+}
+
+func (s *recordingStub) touch(key string) error {
+	if _, ok := s.before[key]; ok {
+		return nil
+	}
+	value, err := s.base.GetState(key)
+	if err != nil {
+		return err
+	}
+	s.before[key] = value
+	return nil
+}
+
+// ============================================================================================================================
+// Write Set - the keys the transaction changed, a key written back to its old value is not a change
+// ============================================================================================================================
+func (s *recordingStub) writeSet() []Write {
+	var keys []string
+	for key := range s.before{
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writes := []Write{}
+	for _, key := range keys{
+		if s.deleted[key] {
+			if s.before[key] != nil {
+				writes = append(writes, Write{Key: key, Deleted: true})
+			}
+		} else if s.before[key] == nil || string(s.before[key]) != string(s.writes[key]) {
+			writes = append(writes, Write{Key: key, Value: string(s.writes[key])})
+		}
+	}
+	return writes
+}
+
+// ============================================================================================================================
+// Execute - run one transaction on the stub and fill in what it did, the caller rolls back a failed invoke
+// ============================================================================================================================
+func execute(cc *marbles.Chaincode, stub marbles.Stub, entry *Entry, prevHash string) ([]byte, error) {
+	recording := newRecordingStub(stub, entry.Caller, entry.Timestamp)
+	var res []byte
+	var err error
+	if entry.Kind == "invoke" {
+		res, err = cc.Invoke(recording, entry.Function, entry.Args)
+	} else {
+		res, err = cc.Read(recording, entry.Function, entry.Args)
+	}
+
+	entry.Result = string(res)
+	entry.Error = ""
+	entry.Writes = []Write{}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Writes = recording.writeSet()
+	}
+	entry.Hash = chainHash(prevHash, entry.Writes)
+	return res, err
+}
+
+// ============================================================================================================================
+// Chain Hash - hash the previous hash with a write set, a transaction without writes keeps the hash
+// ============================================================================================================================
+func chainHash(prevHash string, writes []Write) string {
+	if len(writes) == 0 {
+		return prevHash
+	}
+	jsonAsBytes, _ := json.Marshal(writes)
+	sum := sha256.Sum256(append([]byte(prevHash), jsonAsBytes...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Recorder runs the chaincode and writes every transaction to a log, one JSON entry per line
+type Recorder struct{
+	mu sync.Mutex
+	cc *marbles.Chaincode
+	out io.Writer
+	seq int
+	hash string
+}
+
+// ============================================================================================================================
+// New Recorder - record to out, starting a new log
+// ============================================================================================================================
+func NewRecorder(cc *marbles.Chaincode, out io.Writer) *Recorder {
+	return &Recorder{cc: cc, out: out}
+}
+
+// ============================================================================================================================
+// Open Recorder - record to the end of a log file, carrying on from its last entry
+// Replay starts from an empty ledger, so a new log may only be started on one
+// ============================================================================================================================
+func OpenRecorder(cc *marbles.Chaincode, path string, stub *memstub.Stub) (*Recorder, *os.File, error) {
+	r := NewRecorder(cc, nil)
+	in, err := os.Open(path)
+	if err == nil {
+		entries, err := ReadLog(in)
+		in.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(entries) > 0 {
+			r.seq = entries[len(entries)-1].Seq
+			r.hash = entries[len(entries)-1].Hash
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if r.seq == 0 && len(stub.Keys()) > 0 {
+		return nil, nil, errors.New("State already has " + strconv.Itoa(len(stub.Keys())) + " keys, a new log must start from an empty ledger or it could never be replayed")
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.out = out
+	return r, out, nil
+}
+
+// ============================================================================================================================
+// Invoke - run and record an invoke as the stub's caller, the stub's own timestamp is used if it has one
+// ============================================================================================================================
+func (r *Recorder) Invoke(stub marbles.Stub, function string, args []string) ([]byte, error) {
+	return r.record(stub, "invoke", function, args)
+}
+
+// ============================================================================================================================
+// Read - run and record a query
+// ============================================================================================================================
+func (r *Recorder) Read(stub marbles.Stub, function string, args []string) ([]byte, error) {
+	return r.record(stub, "query", function, args)
+}
+
+func (r *Recorder) record(stub marbles.Stub, kind string, function string, args []string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	if timestampStub, ok := stub.(marbles.TimestampStub); ok {
+		if ts, err := timestampStub.TxTimestamp(); err == nil {
+			timestamp = ts
+		}
+	}
+	caller, _ := stub.GetCaller()
+	entry := Entry{Seq: r.seq + 1, Kind: kind, Function: function, Args: append([]string{}, args...), Caller: caller, Timestamp: timestamp}
+	res, err := execute(r.cc, stub, &entry, r.hash)
+
+	jsonAsBytes, _ := json.Marshal(entry)
+	_, werr := r.out.Write(append(jsonAsBytes, '\n'))
+	if werr != nil {
+		return res, errors.New("Failed to record transaction: " + werr.Error())
+	}
+	r.seq = entry.Seq
+	r.hash = entry.Hash
+	return res, err
+}
+
+// ============================================================================================================================
+// Read Log - read the entries of a log
+// ============================================================================================================================
+func ReadLog(in io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan(){
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, errors.New("Line " + strconv.Itoa(line) + " is not a log entry")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
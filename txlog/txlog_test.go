@@ -0,0 +1,232 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package txlog_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binhn/marbles-chaincode/marbles"
+	"github.com/binhn/marbles-chaincode/memstub"
+	"github.com/binhn/marbles-chaincode/txlog"
+)
+
+var devnull, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+
+// session is what a client did, one transaction a second, the sixth is refused
+var session = [][]string{
+	{"admin", "invoke", "init", "1", "admin"},
+	{"alice", "invoke", "register_user", "alice", "alice", "co"},
+	{"bob", "invoke", "register_user", "bob", "bob", "co"},
+	{"alice", "invoke", "init_marble", "m1", "red", "16", "alice"},
+	{"bob", "invoke", "init_marble", "m2", "blue", "35", "bob"},
+	{"bob", "invoke", "set_user", "m1", "bob"},
+	{"alice", "query", "list_marbles", "{}"},
+	{"alice", "invoke", "set_user", "m1", "bob"},
+	{"bob", "invoke", "init_marble", "m3", "green", "5", "bob"},
+}
+
+// quiet runs f with the chaincode's logging going nowhere
+func quiet(f func()) {
+	stdout := os.Stdout
+	os.Stdout = devnull
+	defer func(){ os.Stdout = stdout }()
+	f()
+}
+
+// record plays the calls through a recorder on the stub and reads back the log
+func record(t *testing.T, recorder *txlog.Recorder, stub *memstub.Stub, out *bytes.Buffer, calls [][]string) []txlog.Entry {
+	t.Helper()
+	quiet(func(){
+		for _, call := range calls{
+			stub.Timestamp += 1000
+			stub.Caller = call[0]
+			if call[1] == "invoke" {
+				before := stub.Snapshot()
+				if _, err := recorder.Invoke(stub, call[2], call[3:]); err != nil {
+					stub.Restore(before)
+				}
+			} else {
+				recorder.Read(stub, call[2], call[3:])
+			}
+		}
+	})
+	entries, err := txlog.ReadLog(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func recordSession(t *testing.T, cc *marbles.Chaincode) []txlog.Entry {
+	stub := memstub.New()
+	stub.Timestamp = 1464000000000
+	var out bytes.Buffer
+	return record(t, txlog.NewRecorder(cc, &out), stub, &out, session)
+}
+
+func TestHashChain(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	entries := recordSession(t, cc)
+	if len(entries) != len(session) {
+		t.Fatalf("%d entries for %d transactions", len(entries), len(session))
+	}
+	prevHash := ""
+	for i, entry := range entries{
+		if entry.Seq != i + 1 || entry.Function != session[i][2] || entry.Caller != session[i][0] || entry.Timestamp != 1464000000000 + int64(i + 1) * 1000 {
+			t.Fatalf("entry %d is %+v", i, entry)
+		}
+		if (entry.Error != "") != (i == 5) {
+			t.Fatalf("entry %d has error %q", i, entry.Error)
+		}
+		if len(entry.Writes) == 0 && entry.Hash != prevHash {
+			t.Errorf("entry %d wrote nothing and moved the hash on", i)
+		}
+		if len(entry.Writes) != 0 && (entry.Hash == prevHash || entry.Hash == "") {
+			t.Errorf("entry %d wrote %d keys and kept the hash", i, len(entry.Writes))
+		}
+		prevHash = entry.Hash
+	}
+
+	replayed, divergence, err := txlog.Replay(cc, entries)
+	if err != nil || divergence != nil {
+		t.Fatalf("replay diverged %+v %v", divergence, err)
+	}
+	if replayed[len(replayed)-1].Hash != prevHash {
+		t.Fatalf("replay ended on hash %s, the log on %s", replayed[len(replayed)-1].Hash, prevHash)
+	}
+	if index, err := txlog.Bisect(cc, entries); index != -1 || err != nil {
+		t.Fatalf("bisect found %d %v in a log that replays", index, err)
+	}
+}
+
+func TestReplayFindsMismatch(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, test := range []struct{
+		name string
+		index int
+		edit func(entry *txlog.Entry)
+		reason string
+	}{
+		{"value", 3, func(entry *txlog.Entry){ entry.Writes[0].Value = "{}" }, "writes " },
+		{"missing write", 4, func(entry *txlog.Entry){ entry.Writes = entry.Writes[1:] }, "now writes "},
+		{"extra write", 4, func(entry *txlog.Entry){ entry.Writes = append(entry.Writes, txlog.Write{Key: "m9", Value: "{}"}) }, "no longer writes m9"},
+		{"deleted", 3, func(entry *txlog.Entry){ entry.Writes[0].Deleted = true }, "writes "},
+		{"error", 5, func(entry *txlog.Entry){ entry.Error = "" }, "error was"},
+		{"query result", 6, func(entry *txlog.Entry){ entry.Result = "{}" }, "query result differs"},
+		{"hash", 8, func(entry *txlog.Entry){ entry.Hash = "beef" }, "hash differs"},
+	}{
+		entries := recordSession(t, cc)
+		test.edit(&entries[test.index])
+		var divergence *txlog.Divergence
+		var err error
+		quiet(func(){ _, divergence, err = txlog.Replay(cc, entries) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if divergence == nil || divergence.Index != test.index || !strings.HasPrefix(divergence.Reason, test.reason) {
+			t.Errorf("%s: replay diverged %+v, want %q at %d", test.name, divergence, test.reason, test.index)
+		}
+	}
+}
+
+func TestBisectFindsFirstDivergence(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	for _, test := range []struct{
+		name string
+		index int
+		edit func(entry *txlog.Entry)
+	}{
+		{"args", 3, func(entry *txlog.Entry){ entry.Args[1] = "purple" }},
+		{"caller", 1, func(entry *txlog.Entry){ entry.Caller = "mallory" }},
+		{"last", 8, func(entry *txlog.Entry){ entry.Args[0] = "m4" }},
+		{"refused", 5, func(entry *txlog.Entry){ entry.Args = []string{"m2", "alice"} }},//works now, where it was refused
+	}{
+		entries := recordSession(t, cc)
+		test.edit(&entries[test.index])
+		var index int
+		var err error
+		quiet(func(){ index, err = txlog.Bisect(cc, entries) })
+		if err != nil || index != test.index {
+			t.Errorf("%s: bisect found %d %v, want %d", test.name, index, err, test.index)
+		}
+	}
+}
+
+func TestLogMustStartAtTheFirstTransaction(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	entries := recordSession(t, cc)
+	for _, broken := range [][]txlog.Entry{entries[1:], append(append([]txlog.Entry{}, entries[:3]...), entries[4:]...)}{
+		if _, _, err := txlog.Replay(cc, broken); err == nil {
+			t.Error("replayed a log with entries missing")
+		}
+		if _, err := txlog.Bisect(cc, broken); err == nil {
+			t.Error("bisected a log with entries missing")
+		}
+	}
+	if _, err := txlog.ReadLog(strings.NewReader("{\"seq\": 1}\nnot json\n")); err == nil {
+		t.Error("read a log with a broken line")
+	}
+}
+
+func TestOpenRecorderCarriesOn(t *testing.T) {
+	cc := marbles.NewChaincode(marbles.Part2)
+	dir, err := ioutil.TempDir("", "txlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tx.log")
+
+	stub := memstub.New()
+	stub.Timestamp = 1464000000000
+	for _, calls := range [][][]string{session[:4], session[4:]}{				//the second recorder picks up where the first stopped
+		recorder, file, err := txlog.OpenRecorder(cc, path, stub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var none bytes.Buffer
+		record(t, recorder, stub, &none, calls)
+		file.Close()
+	}
+
+	in, _ := os.Open(path)
+	entries, err := txlog.ReadLog(in)
+	in.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(session) {
+		t.Fatalf("%d entries for %d transactions", len(entries), len(session))
+	}
+	var divergence *txlog.Divergence
+	quiet(func(){ _, divergence, err = txlog.Replay(cc, entries) })
+	if err != nil || divergence != nil {
+		t.Fatalf("replay diverged %+v %v", divergence, err)
+	}
+
+	if _, _, err := txlog.OpenRecorder(cc, filepath.Join(dir, "new.log"), stub); err == nil {
+		t.Fatal("started a new log on a ledger that already has state")
+	}
+}